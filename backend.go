@@ -2,8 +2,12 @@ package buddysecrets
 
 import (
 	"context"
+	"fmt"
+	"github.com/buddy/vault-plugin-secrets-engine-buddy/version"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/consts"
 	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/time/rate"
 	"os"
 	"strings"
 	"sync"
@@ -13,45 +17,105 @@ import (
 type buddySecretBackend struct {
 	*framework.Backend
 	client *client
-	lock   sync.RWMutex
+	// workspaceClients are the clients of the workspace root tokens, by workspace domain
+	workspaceClients map[string]*client
+	lock             sync.RWMutex
+	// poolLock guards the pooled tokens in the storage
+	poolLock sync.Mutex
+	// poolRefillLock prevents concurrent refills of the pools
+	poolRefillLock sync.Mutex
+	// refillLock guards the queue of the background refills
+	refillLock    sync.Mutex
+	refillQueue   map[string]bool
+	refillAll     bool
+	refillRunning bool
+	refillWg      sync.WaitGroup
+	// ctx is cancelled when the mount is cleaned up, stopping the background refills
+	ctx    context.Context
+	cancel context.CancelFunc
+	// storage is the storage of the mount, used when the mount is cleaned up
+	storage logical.Storage
+	// usageLock guards the lease counters and the issue rate limiters
+	usageLock sync.Mutex
+	// importLock prevents concurrent imports
+	importLock    sync.Mutex
+	issueLimiters map[string]*rate.Limiter
 }
 
 func backend() *buddySecretBackend {
-	var b = buddySecretBackend{}
+	var b = buddySecretBackend{
+		issueLimiters:    map[string]*rate.Limiter{},
+		workspaceClients: map[string]*client{},
+		refillQueue:      map[string]bool{},
+	}
+	b.ctx, b.cancel = context.WithCancel(context.Background())
 	b.Backend = &framework.Backend{
-		Help:        strings.TrimSpace(backendHelp),
-		BackendType: logical.TypeLogical,
+		Help:           strings.TrimSpace(backendHelp),
+		BackendType:    logical.TypeLogical,
+		RunningVersion: version.Version,
 		PathsSpecial: &logical.Paths{
-			SealWrapStorage: []string{
-				"config",
+			// issued tokens, pools and lease counters belong to the cluster which issued the leases
+			LocalStorage: []string{
+				tokensStoragePath + "/",
+				poolStoragePath + "/",
+				usageStoragePath + "/",
 			},
+			SealWrapStorage: sealWrapStorage,
 		},
 		Paths: framework.PathAppend(
 			[]*framework.Path{
 				pathConfig(&b),
+				pathConfigPolicy(&b),
 				pathRotateConfig(&b),
+				pathHealth(&b),
+				pathVersion(&b),
 				pathRole(&b),
 				pathRoles(&b),
+				pathRoleUsage(&b),
+				pathRoleTemplate(&b),
+				pathRoleTemplates(&b),
+				pathScopeSet(&b),
+				pathScopeSets(&b),
 				pathToken(&b),
+				pathIntegrationRole(&b),
+				pathIntegrationRoles(&b),
+				pathIntegration(&b),
+				pathElevationRole(&b),
+				pathElevationRoles(&b),
+				pathElevation(&b),
 			},
+			pathTokens(&b),
+			pathRevoke(&b),
+			pathRoleVersions(&b),
+			pathExport(&b),
 		),
 		Secrets: []*framework.Secret{
 			secretToken(&b),
+			secretIntegration(&b),
+			secretElevation(&b),
 		},
-		Invalidate:   b.invalidate,
-		PeriodicFunc: b.periodic,
+		InitializeFunc: b.initialize,
+		Clean:          b.cleanup,
+		Invalidate:     b.invalidate,
+		PeriodicFunc:   b.periodic,
 	}
 	return &b
 }
 
-func (b *buddySecretBackend) getNewClient(config *buddyConfig) (*client, error) {
+func (b *buddySecretBackend) getNewClient(ctx context.Context, config *buddyConfig) (*client, error) {
+	if config.usesIdentityToken() {
+		if err := b.exchangeIdentityToken(ctx, config); err != nil {
+			return nil, err
+		}
+	}
 	apiClient, err := NewApiClient(config)
 	if err != nil {
 		return nil, err
 	}
 	c := &client{
-		expiration: time.Now().Add(clientLifetime),
+		expiration: clientExpiration(config),
 		apiClient:  apiClient,
+		logger:     b.Logger(),
 	}
 	return c, nil
 }
@@ -73,27 +137,70 @@ func (b *buddySecretBackend) getClient(ctx context.Context, s logical.Storage) (
 	if err != nil {
 		return nil, err
 	}
+	if config.usesIdentityToken() {
+		if err := b.exchangeIdentityToken(ctx, config); err != nil {
+			return nil, err
+		}
+	}
+	// Buddy may rotate the refresh token, so only the node writing the config can exchange it
+	if config.usesOAuth() && !config.accessTokenValid() && !b.sharedStorageWritable() {
+		return nil, fmt.Errorf("oauth access token expired, it is refreshed by the active node of the primary cluster")
+	}
+	accessToken := config.AccessToken
 	apiClient, err := NewApiClient(config)
 	if err != nil {
 		return nil, err
 	}
+	// persist refreshed oauth tokens, the refresh token may have been rotated
+	if config.usesOAuth() && config.AccessToken != accessToken {
+		if err := putConfig(ctx, config, s); err != nil {
+			return nil, err
+		}
+	}
+	// config could have been changed on another node
+	b.applyLogLevel(config)
 	c := &client{
-		expiration: time.Now().Add(clientLifetime),
+		expiration: clientExpiration(config),
 		apiClient:  apiClient,
+		logger:     b.Logger(),
 	}
 	b.client = c
 	return c, nil
 }
 
-// reset clears the backend's client
+// sharedStorageWritable reports whether the node can write the replicated storage, e.g. config and roles
+func (b *buddySecretBackend) sharedStorageWritable() bool {
+	return !b.System().ReplicationState().HasState(consts.ReplicationPerformanceSecondary | consts.ReplicationPerformanceStandby)
+}
+
+// reset clears the backend's clients
 func (b *buddySecretBackend) reset() {
 	b.lock.Lock()
 	defer b.lock.Unlock()
+	b.resetLocked()
+}
+
+// resetLocked clears the backend's clients, the caller must hold b.lock
+func (b *buddySecretBackend) resetLocked() {
 	b.client = nil
+	b.workspaceClients = map[string]*client{}
+}
+
+// initialize migrates the storage and applies the log level of the stored config when the mount is set up
+func (b *buddySecretBackend) initialize(ctx context.Context, req *logical.InitializationRequest) error {
+	if err := b.migrateStorage(ctx, req.Storage); err != nil {
+		return err
+	}
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return err
+	}
+	b.applyLogLevel(config)
+	return nil
 }
 
 func (b *buddySecretBackend) periodic(ctx context.Context, sys *logical.Request) error {
-	b.Logger().Info("starting periodic function")
+	b.Logger().Debug("starting periodic function")
 	config, err := b.getConfig(ctx, sys.Storage)
 	if err != nil {
 		return err
@@ -101,28 +208,75 @@ func (b *buddySecretBackend) periodic(ctx context.Context, sys *logical.Request)
 	if config == nil {
 		return nil
 	}
+	if err := b.refillPools(ctx, sys.Storage); err != nil {
+		b.Logger().Warn("error while refilling token pools", "error", config.redact(err.Error()))
+	}
+	// root credentials are rotated by the primary cluster and replicated
+	if !b.sharedStorageWritable() {
+		return nil
+	}
+	if len(config.WorkspaceTokens) > 0 {
+		for _, domain := range config.workspaceDomains() {
+			if err := b.periodicWorkspace(ctx, sys, domain); err != nil {
+				b.Logger().Warn("error while rotating workspace root token", "workspace", domain, "error", config.redact(err.Error()))
+			}
+		}
+		// the rotated workspace root tokens were saved in config
+		config, err = b.getConfig(ctx, sys.Storage)
+		if err != nil {
+			return err
+		}
+		if config == nil {
+			return nil
+		}
+	}
+	if config.usesIdentityToken() {
+		// nothing to rotate, access tokens are exchanged on demand
+		return nil
+	}
+	if config.usesOAuth() {
+		return b.periodicOAuth(ctx, sys, config)
+	}
+	logger := b.Logger().With("token_id", config.TokenId)
 	if !config.TokenAutoRotate {
-		b.Logger().Info("no need to rotate root token")
+		logger.Debug("no need to rotate root token")
 	}
 	now := time.Now()
 	if !config.TokenNoExpiration && config.TokenExpiresAt.Unix() < now.Unix() {
-		b.Logger().Info("root token expired - disabling auto rotate")
+		logger.Warn("root token expired - disabling auto rotate", "expires_at", config.TokenExpiresAt)
 		config.TokenAutoRotate = false
 		return b.saveConfig(ctx, config, sys.Storage)
 	}
 	forceRotate := os.Getenv("BUDDY_FORCE_RORATE") == "true"
 	if forceRotate || config.TokenAutoRotateAt.Unix() < now.Unix() {
-		b.Logger().Info("rotating root token")
-		err := b.rotateRootToken(ctx, sys)
+		logger.Info("rotating root token", "rotate_at", config.TokenAutoRotateAt, "forced", forceRotate)
+		err := b.rotateRootToken(ctx, sys, "")
 		if err != nil {
-			b.Logger().Info("error while rotating token - will try in an hour", err.Error())
 			config.TokenAutoRotateAt = config.TokenAutoRotateAt.Add(time.Hour)
+			logger.Error("error while rotating root token - will try in an hour", "retry_at", config.TokenAutoRotateAt, "error", config.redact(err.Error()))
 			return b.saveConfig(ctx, config, sys.Storage)
 		}
 	}
 	return nil
 }
 
+// periodicOAuth exchanges the refresh token of the OAuth application once per oauthRefreshInterval
+// and before the stored access token expires, so performance secondaries always read a valid one
+func (b *buddySecretBackend) periodicOAuth(ctx context.Context, sys *logical.Request, config *buddyConfig) error {
+	// refreshed ahead of the clients, which stop using the access token oauthAccessTokenMargin before it expires
+	accessTokenFresh := time.Now().Add(2 * oauthAccessTokenMargin).Before(config.AccessTokenExpiresAt)
+	if time.Now().Before(config.RefreshedAt.Add(oauthRefreshInterval)) && accessTokenFresh {
+		return nil
+	}
+	logger := b.Logger().With("client_id", config.ClientId)
+	logger.Info("rotating oauth refresh token", "refreshed_at", config.RefreshedAt)
+	err := b.rotateRootToken(ctx, sys, "")
+	if err != nil {
+		logger.Error("error while rotating oauth refresh token - will try on next run", "error", config.redact(err.Error()))
+	}
+	return nil
+}
+
 func (b *buddySecretBackend) invalidate(_ context.Context, key string) {
 	switch key {
 	case "config":
@@ -132,9 +286,15 @@ func (b *buddySecretBackend) invalidate(_ context.Context, key string) {
 
 func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend, error) {
 	b := backend()
-	if err := b.Setup(ctx, conf); err != nil {
+	// the logger of Vault is shared by the mounts of the plugin, log_level changes the copy of the mount
+	mountConf := *conf
+	if conf.Logger != nil {
+		mountConf.Logger = newMountLogger(conf.Logger)
+	}
+	if err := b.Setup(ctx, &mountConf); err != nil {
 		return nil, err
 	}
+	b.storage = conf.StorageView
 	return b, nil
 }
 
@@ -0,0 +1,188 @@
+package buddysecrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/buddy/api-go-sdk/buddy"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/logical"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBuddy is the in-memory Buddy API managing the personal access tokens
+type fakeBuddy struct {
+	server *httptest.Server
+	lock   sync.Mutex
+	// tokens by token value
+	tokens map[string]*buddy.Token
+	next   int
+}
+
+func newFakeBuddy(t *testing.T) *fakeBuddy {
+	f := &fakeBuddy{
+		tokens: map[string]*buddy.Token{},
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeBuddy) handle(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	me, ok := f.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+	if !ok {
+		f.writeError(w, http.StatusUnauthorized, "Wrong authentication data")
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/user/token":
+		_ = json.NewEncoder(w).Encode(me)
+	case r.Method == http.MethodPost && r.URL.Path == "/user/tokens":
+		ops := new(buddy.TokenOps)
+		if err := json.NewDecoder(r.Body).Decode(ops); err != nil {
+			f.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		var scopes, workspaces []string
+		if ops.Scopes != nil {
+			scopes = *ops.Scopes
+		}
+		if ops.WorkspaceRestrictions != nil {
+			workspaces = *ops.WorkspaceRestrictions
+		}
+		token := f.newToken(scopes, workspaces)
+		if ops.IpRestrictions != nil {
+			token.IpRestrictions = *ops.IpRestrictions
+		}
+		_ = json.NewEncoder(w).Encode(token)
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/user/tokens/"):
+		id := strings.TrimPrefix(r.URL.Path, "/user/tokens/")
+		for value, token := range f.tokens {
+			if token.Id == id {
+				delete(f.tokens, value)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		f.writeError(w, http.StatusNotFound, "Token not found")
+	default:
+		f.writeError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+func (f *fakeBuddy) writeError(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]string{{"message": message}},
+	})
+}
+
+func (f *fakeBuddy) newToken(scopes []string, workspaces []string) *buddy.Token {
+	f.next++
+	token := &buddy.Token{
+		Id:                    fmt.Sprintf("id-%d", f.next),
+		Token:                 fmt.Sprintf("token-%d", f.next),
+		ExpiresAt:             time.Now().Add(90 * 24 * time.Hour).UTC().Format(time.RFC3339),
+		Scopes:                scopes,
+		IpRestrictions:        []string{},
+		WorkspaceRestrictions: workspaces,
+	}
+	f.tokens[token.Token] = token
+	return token
+}
+
+// addRootToken creates the token which can manage tokens, restricted to the given workspaces
+func (f *fakeBuddy) addRootToken(workspaces ...string) *buddy.Token {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.newToken([]string{buddy.TokenScopeTokenManage, buddy.TokenScopeWorkspace, buddy.TokenScopeExecutionRun}, workspaces)
+}
+
+// exists reports whether the token with the id was not deleted
+func (f *fakeBuddy) exists(id string) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, token := range f.tokens {
+		if token.Id == id {
+			return true
+		}
+	}
+	return false
+}
+
+// getTestBackend returns the backend with the in-memory storage and the replication state of the node
+func getTestBackend(t *testing.T, state consts.ReplicationState) (*buddySecretBackend, logical.Storage) {
+	t.Helper()
+	s := &logical.InmemStorage{}
+	return getTestBackendWithStorage(t, state, s), s
+}
+
+// getTestBackendWithStorage returns the backend of the node with the given storage, e.g. shared with another node
+func getTestBackendWithStorage(t *testing.T, state consts.ReplicationState, s logical.Storage) *buddySecretBackend {
+	t.Helper()
+	config := logical.TestBackendConfig()
+	config.StorageView = s
+	config.System.(*logical.StaticSystemView).ReplicationStateVal = state
+	b, err := Factory(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		b.Cleanup(context.Background())
+	})
+	return b.(*buddySecretBackend)
+}
+
+// testRequest handles the request and fails on the internal errors, error responses are returned
+func testRequest(t *testing.T, b *buddySecretBackend, s logical.Storage, op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+	t.Helper()
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: op,
+		Path:      path,
+		Storage:   s,
+		Data:      data,
+	})
+	if err != nil {
+		t.Fatalf("%s %s: %s", op, path, err)
+	}
+	return resp
+}
+
+// testOk handles the request and fails on any error
+func testOk(t *testing.T, b *buddySecretBackend, s logical.Storage, op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+	t.Helper()
+	resp := testRequest(t, b, s, op, path, data)
+	if resp != nil && resp.IsError() {
+		t.Fatalf("%s %s: %s", op, path, resp.Error())
+	}
+	return resp
+}
+
+// testConfigure saves the config with the new root token of the fake Buddy and returns the token
+func testConfigure(t *testing.T, b *buddySecretBackend, s logical.Storage, f *fakeBuddy) *buddy.Token {
+	t.Helper()
+	root := f.addRootToken()
+	testOk(t, b, s, logical.CreateOperation, "config", map[string]interface{}{
+		"token":    root.Token,
+		"base_url": f.server.URL,
+	})
+	return root
+}
+
+// testRevoke revokes the lease of the secret as Vault does on expiration
+func testRevoke(t *testing.T, b *buddySecretBackend, s logical.Storage, secret *logical.Secret) error {
+	t.Helper()
+	_, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RevokeOperation,
+		Storage:   s,
+		Secret:    secret,
+	})
+	return err
+}
@@ -1,7 +1,12 @@
 package buddysecrets
 
 import (
+	"crypto/tls"
+	"errors"
 	"github.com/buddy/api-go-sdk/buddy"
+	"github.com/hashicorp/go-hclog"
+	"net/http"
+	"strings"
 	"time"
 )
 
@@ -12,12 +17,27 @@ const (
 type client struct {
 	apiClient  *buddy.Client
 	expiration time.Time
+	logger     hclog.Logger
 }
 
 func (c *client) Valid() bool {
 	return c != nil && time.Now().Before(c.expiration)
 }
 
+// observe records the metrics of the Buddy API call and logs it
+func (c *client) observe(operation string, start time.Time, resp *http.Response, err error) {
+	observeApiCall(operation, start, resp, err)
+	if c.logger == nil {
+		return
+	}
+	args := []interface{}{"operation", operation, "status", apiStatus(resp, err), "duration", time.Since(start)}
+	if err != nil {
+		c.logger.Debug("buddy api call failed", append(args, "error", err)...)
+		return
+	}
+	c.logger.Trace("buddy api call", args...)
+}
+
 func (c *client) CreateToken(name string, expiresIn int, ipRestrictions []string, workspaceRestrictions []string, scopes []string) (*buddy.Token, error) {
 	ops := buddy.TokenOps{
 		Name:                  &name,
@@ -26,7 +46,9 @@ func (c *client) CreateToken(name string, expiresIn int, ipRestrictions []string
 		Scopes:                &scopes,
 		ExpiresIn:             &expiresIn,
 	}
-	token, _, err := c.apiClient.TokenService.Create(&ops)
+	start := time.Now()
+	token, resp, err := c.apiClient.TokenService.Create(&ops)
+	c.observe("create_token", start, resp, err)
 	if err != nil {
 		return nil, err
 	}
@@ -34,15 +56,176 @@ func (c *client) CreateToken(name string, expiresIn int, ipRestrictions []string
 }
 
 func (c *client) DeleteToken(tokenId string) error {
-	_, err := c.apiClient.TokenService.Delete(tokenId)
+	start := time.Now()
+	resp, err := c.apiClient.TokenService.Delete(tokenId)
+	c.observe("delete_token", start, resp, err)
+	return err
+}
+
+func (c *client) CreateIntegration(domain string, ops *buddy.IntegrationOps) (*buddy.Integration, error) {
+	start := time.Now()
+	integration, resp, err := c.apiClient.IntegrationService.Create(domain, ops)
+	c.observe("create_integration", start, resp, err)
+	if err != nil {
+		return nil, err
+	}
+	return integration, nil
+}
+
+func (c *client) DeleteIntegration(domain string, hashId string) error {
+	start := time.Now()
+	resp, err := c.apiClient.IntegrationService.Delete(domain, hashId)
+	c.observe("delete_integration", start, resp, err)
+	return err
+}
+
+func (c *client) GetMember(domain string, memberId int) (*buddy.Member, error) {
+	start := time.Now()
+	member, resp, err := c.apiClient.MemberService.Get(domain, memberId)
+	c.observe("get_member", start, resp, err)
+	if err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+func (c *client) FindMemberByEmail(domain string, email string) (*buddy.Member, error) {
+	start := time.Now()
+	members, resp, err := c.apiClient.MemberService.GetListAll(domain)
+	c.observe("list_members", start, resp, err)
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range members.Members {
+		if strings.EqualFold(member.Email, email) {
+			return member, nil
+		}
+	}
+	return nil, nil
+}
+
+func (c *client) GetGroupMember(domain string, groupId int, memberId int) (*buddy.Member, error) {
+	start := time.Now()
+	member, resp, err := c.apiClient.GroupService.GetGroupMember(domain, groupId, memberId)
+	c.observe("get_group_member", start, resp, err)
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+func (c *client) AddGroupMember(domain string, groupId int, memberId int) error {
+	start := time.Now()
+	_, resp, err := c.apiClient.GroupService.AddGroupMember(domain, groupId, &buddy.GroupMemberOps{
+		Id: &memberId,
+	})
+	c.observe("add_group_member", start, resp, err)
+	return err
+}
+
+func (c *client) DeleteGroupMember(domain string, groupId int, memberId int) error {
+	start := time.Now()
+	resp, err := c.apiClient.GroupService.DeleteGroupMember(domain, groupId, memberId)
+	c.observe("delete_group_member", start, resp, err)
+	return err
+}
+
+func (c *client) GetProjectMember(domain string, projectName string, memberId int) (*buddy.ProjectMember, error) {
+	start := time.Now()
+	member, resp, err := c.apiClient.ProjectMemberService.GetProjectMember(domain, projectName, memberId)
+	c.observe("get_project_member", start, resp, err)
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+func (c *client) AddProjectMember(domain string, projectName string, memberId int, permissionId int) error {
+	start := time.Now()
+	_, resp, err := c.apiClient.ProjectMemberService.CreateProjectMember(domain, projectName, &buddy.ProjectMemberOps{
+		Id: &memberId,
+		PermissionSet: &buddy.ProjectMemberOps{
+			Id: &permissionId,
+		},
+	})
+	c.observe("add_project_member", start, resp, err)
+	return err
+}
+
+func (c *client) UpdateProjectMember(domain string, projectName string, memberId int, permissionId int) error {
+	start := time.Now()
+	_, resp, err := c.apiClient.ProjectMemberService.UpdateProjectMember(domain, projectName, memberId, &buddy.ProjectMemberOps{
+		PermissionSet: &buddy.ProjectMemberOps{
+			Id: &permissionId,
+		},
+	})
+	c.observe("update_project_member", start, resp, err)
+	return err
+}
+
+func (c *client) DeleteProjectMember(domain string, projectName string, memberId int) error {
+	start := time.Now()
+	resp, err := c.apiClient.ProjectMemberService.DeleteProjectMember(domain, projectName, memberId)
+	c.observe("delete_project_member", start, resp, err)
 	return err
 }
 
 func (c *client) GetRootToken() (*buddy.Token, error) {
-	token, _, err := c.apiClient.TokenService.GetMe()
+	start := time.Now()
+	token, resp, err := c.apiClient.TokenService.GetMe()
+	c.observe("get_root_token", start, resp, err)
 	return token, err
 }
 
+func isNotFound(err error) bool {
+	var errResp *buddy.ErrorResponse
+	return errors.As(err, &errResp) && errResp.Response != nil && errResp.Response.StatusCode == http.StatusNotFound
+}
+
+// NewApiClient creates the Buddy API client authorized with the root credential.
+// For OAuth applications the access token is refreshed in config when needed
 func NewApiClient(config *buddyConfig) (*buddy.Client, error) {
-	return buddy.NewClient(config.Token, config.BaseUrl, config.Insecure)
+	token := config.Token
+	if config.usesOAuth() {
+		if !config.accessTokenValid() {
+			if err := refreshAccessToken(config); err != nil {
+				return nil, err
+			}
+		}
+		token = config.AccessToken
+	} else if config.usesIdentityToken() {
+		// exchanged by the backend, see exchangeIdentityToken
+		token = config.AccessToken
+	}
+	return buddy.NewClient(token, config.BaseUrl, config.Insecure)
+}
+
+// newHttpClient creates the HTTP client for the calls made outside the Buddy SDK
+func newHttpClient(insecure bool, timeout time.Duration) *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = &tls.Config{
+		InsecureSkipVerify: insecure,
+	}
+	return &http.Client{
+		Transport: t,
+		Timeout:   timeout,
+	}
+}
+
+// clientExpiration returns when the client built from config must be recreated
+func clientExpiration(config *buddyConfig) time.Time {
+	expiration := time.Now().Add(clientLifetime)
+	if config.usesOAuth() || config.usesIdentityToken() {
+		accessTokenExpiration := config.AccessTokenExpiresAt.Add(-oauthAccessTokenMargin)
+		if accessTokenExpiration.Before(expiration) {
+			return accessTokenExpiration
+		}
+	}
+	return expiration
 }
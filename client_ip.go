@@ -0,0 +1,77 @@
+package buddysecrets
+
+import (
+	"fmt"
+	"github.com/hashicorp/vault/sdk/logical"
+	"net"
+	"strings"
+)
+
+// parseIpRange parses the IP address or the CIDR of ip_restrictions
+func parseIpRange(value string) (*net.IPNet, error) {
+	if strings.Contains(value, "/") {
+		_, ipNet, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ip restriction '%s'", value)
+		}
+		return ipNet, nil
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid ip restriction '%s'", value)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// clientIpRange returns the range of the role around the address of the client. Vault sets the
+// remote address from X-Forwarded-For only for the trusted proxies of the listener
+func clientIpRange(req *logical.Request, role *roleEntry) (*net.IPNet, error) {
+	if req.Connection == nil || req.Connection.RemoteAddr == "" {
+		return nil, fmt.Errorf("binds tokens to the client address, which is unknown")
+	}
+	ip := net.ParseIP(req.Connection.RemoteAddr)
+	if ip == nil {
+		return nil, fmt.Errorf("binds tokens to the client address, which is invalid: %s", req.Connection.RemoteAddr)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		prefix := role.ClientIpPrefix
+		if prefix == 0 {
+			prefix = 32
+		}
+		mask := net.CIDRMask(prefix, 32)
+		return &net.IPNet{IP: ip4.Mask(mask), Mask: mask}, nil
+	}
+	prefix := role.ClientIpv6Prefix
+	if prefix == 0 {
+		prefix = 128
+	}
+	mask := net.CIDRMask(prefix, 128)
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}, nil
+}
+
+// bindClientIp returns the ip restrictions of the token bound to the client. The range around the client
+// address must lie within one of the role ip_restrictions, if the role has any
+func bindClientIp(req *logical.Request, role *roleEntry) ([]string, error) {
+	clientRange, err := clientIpRange(req, role)
+	if err != nil {
+		return nil, err
+	}
+	if len(role.IpRestrictions) == 0 {
+		return []string{clientRange.String()}, nil
+	}
+	clientOnes, clientBits := clientRange.Mask.Size()
+	for _, restriction := range role.IpRestrictions {
+		allowed, err := parseIpRange(restriction)
+		if err != nil {
+			return nil, err
+		}
+		ones, bits := allowed.Mask.Size()
+		if bits == clientBits && ones <= clientOnes && allowed.Contains(clientRange.IP) {
+			return []string{clientRange.String()}, nil
+		}
+	}
+	return nil, fmt.Errorf("does not allow the client address %s", clientRange)
+}
@@ -0,0 +1,153 @@
+package buddysecrets
+
+import (
+	"github.com/hashicorp/vault/sdk/logical"
+	"slices"
+	"testing"
+)
+
+func TestBindClientIp(t *testing.T) {
+	tests := []struct {
+		name       string
+		connection *logical.Connection
+		role       *roleEntry
+		want       []string
+		wantErr    bool
+	}{
+		{
+			name:       "ipv4 address",
+			connection: &logical.Connection{RemoteAddr: "10.1.2.3"},
+			role:       &roleEntry{},
+			want:       []string{"10.1.2.3/32"},
+		},
+		{
+			name:       "ipv4 prefix",
+			connection: &logical.Connection{RemoteAddr: "10.1.2.3"},
+			role:       &roleEntry{ClientIpPrefix: 24},
+			want:       []string{"10.1.2.0/24"},
+		},
+		{
+			name:       "ipv6 address",
+			connection: &logical.Connection{RemoteAddr: "2001:db8::1"},
+			role:       &roleEntry{},
+			want:       []string{"2001:db8::1/128"},
+		},
+		{
+			name:       "ipv6 prefix",
+			connection: &logical.Connection{RemoteAddr: "2001:db8:0:1::1"},
+			role:       &roleEntry{ClientIpv6Prefix: 48},
+			want:       []string{"2001:db8::/48"},
+		},
+		{
+			name:       "ipv4 prefix does not apply to ipv6",
+			connection: &logical.Connection{RemoteAddr: "2001:db8::1"},
+			role:       &roleEntry{ClientIpPrefix: 24},
+			want:       []string{"2001:db8::1/128"},
+		},
+		{
+			name:       "within ip restriction",
+			connection: &logical.Connection{RemoteAddr: "10.1.2.3"},
+			role:       &roleEntry{ClientIpPrefix: 24, IpRestrictions: []string{"192.168.0.1", "10.0.0.0/8"}},
+			want:       []string{"10.1.2.0/24"},
+		},
+		{
+			name:       "within single address restriction",
+			connection: &logical.Connection{RemoteAddr: "10.1.2.3"},
+			role:       &roleEntry{IpRestrictions: []string{"10.1.2.3"}},
+			want:       []string{"10.1.2.3/32"},
+		},
+		{
+			name:       "within ipv6 restriction",
+			connection: &logical.Connection{RemoteAddr: "2001:db8::1"},
+			role:       &roleEntry{ClientIpv6Prefix: 64, IpRestrictions: []string{"10.0.0.0/8", "2001:db8::/32"}},
+			want:       []string{"2001:db8::/64"},
+		},
+		{
+			name:       "outside of ip restrictions",
+			connection: &logical.Connection{RemoteAddr: "172.16.0.1"},
+			role:       &roleEntry{IpRestrictions: []string{"10.0.0.0/8"}},
+			wantErr:    true,
+		},
+		{
+			name:       "prefix wider than ip restriction",
+			connection: &logical.Connection{RemoteAddr: "10.1.2.3"},
+			role:       &roleEntry{ClientIpPrefix: 8, IpRestrictions: []string{"10.1.0.0/16"}},
+			wantErr:    true,
+		},
+		{
+			name:       "ipv4 client with ipv6 restriction",
+			connection: &logical.Connection{RemoteAddr: "10.1.2.3"},
+			role:       &roleEntry{IpRestrictions: []string{"::/0"}},
+			wantErr:    true,
+		},
+		{
+			name:       "invalid ip restriction",
+			connection: &logical.Connection{RemoteAddr: "10.1.2.3"},
+			role:       &roleEntry{IpRestrictions: []string{"10.0.0.0/33"}},
+			wantErr:    true,
+		},
+		{
+			name:       "missing connection",
+			connection: nil,
+			role:       &roleEntry{},
+			wantErr:    true,
+		},
+		{
+			name:       "missing remote address",
+			connection: &logical.Connection{},
+			role:       &roleEntry{},
+			wantErr:    true,
+		},
+		{
+			name:       "invalid remote address",
+			connection: &logical.Connection{RemoteAddr: "10.1.2.3:8200"},
+			role:       &roleEntry{},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := bindClientIp(&logical.Request{Connection: tt.connection}, tt.role)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestBindClientIpValidatesResolvedRole(t *testing.T) {
+	b, s := getTestBackend(t, 0)
+	testOk(t, b, s, logical.CreateOperation, "role-templates/t1", map[string]interface{}{
+		"ip_restrictions": "10.0.0.0/33",
+	})
+	resp := testRequest(t, b, s, logical.CreateOperation, "roles/r1", map[string]interface{}{
+		"scopes":            "WORKSPACE",
+		"template":          "t1",
+		"bind_to_client_ip": true,
+	})
+	if !resp.IsError() {
+		t.Fatal("invalid ip_restrictions of the template must be rejected")
+	}
+	resp = testRequest(t, b, s, logical.CreateOperation, "roles/r2", map[string]interface{}{
+		"scopes":            "WORKSPACE",
+		"ip_restrictions":   "not an address",
+		"bind_to_client_ip": true,
+	})
+	if !resp.IsError() {
+		t.Fatal("invalid ip_restrictions of the role must be rejected")
+	}
+	testOk(t, b, s, logical.CreateOperation, "roles/r3", map[string]interface{}{
+		"scopes":            "WORKSPACE",
+		"ip_restrictions":   "10.0.0.0/8",
+		"bind_to_client_ip": true,
+	})
+}
@@ -17,6 +17,12 @@ func main() {
 		os.Exit(1)
 	}
 
+	err = buddysecrets.ConfigureMetrics()
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
 	tlsConfig := apiClientMeta.GetTLSConfig()
 	tlsProviderFunc := api.VaultPluginTLSProvider(tlsConfig)
 
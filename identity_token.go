@@ -0,0 +1,44 @@
+package buddysecrets
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/vault/sdk/helper/pluginutil"
+	"net/url"
+	"time"
+)
+
+const (
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	jwtTokenType           = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+func (c *buddyConfig) usesIdentityToken() bool {
+	return c.IdentityTokenAudience != ""
+}
+
+// exchangeIdentityToken generates the plugin identity token signed by Vault and exchanges it
+// for a Buddy access token through the OIDC trust configured in Buddy
+func (b *buddySecretBackend) exchangeIdentityToken(ctx context.Context, config *buddyConfig) error {
+	if config.accessTokenValid() {
+		return nil
+	}
+	identityToken, err := b.System().GenerateIdentityToken(ctx, &pluginutil.IdentityTokenRequest{
+		Audience: config.IdentityTokenAudience,
+		TTL:      config.IdentityTokenTTL,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to generate plugin identity token: %w", err)
+	}
+	form := url.Values{}
+	form.Set("grant_type", tokenExchangeGrantType)
+	form.Set("subject_token_type", jwtTokenType)
+	form.Set("subject_token", identityToken.Token.Token())
+	tokenResp, err := requestAccessToken(config, form)
+	if err != nil {
+		return err
+	}
+	config.AccessToken = tokenResp.AccessToken
+	config.AccessTokenExpiresAt = time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
+	return nil
+}
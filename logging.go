@@ -0,0 +1,121 @@
+package buddysecrets
+
+import (
+	"fmt"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/logical"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	redactedValue = "[redacted]"
+)
+
+// parseLogLevel validates the log_level of the config, empty level keeps the level of Vault
+func parseLogLevel(level string) (string, error) {
+	if level == "" {
+		return "", nil
+	}
+	parsed := hclog.LevelFromString(level)
+	if parsed == hclog.NoLevel || parsed == hclog.Off {
+		return "", fmt.Errorf("invalid log_level '%s', must be one of: trace, debug, info, warn, error", level)
+	}
+	return parsed.String(), nil
+}
+
+// mountLogger filters the logger of Vault with the level of the mount. The plugin process serves many
+// mounts with the same logger, so its level is never changed
+type mountLogger struct {
+	hclog.Logger
+	// level is shared by the loggers derived with With and Named, NoLevel logs everything the logger of Vault does
+	level *atomic.Int32
+}
+
+func newMountLogger(logger hclog.Logger) *mountLogger {
+	level := new(atomic.Int32)
+	level.Store(int32(hclog.NoLevel))
+	return &mountLogger{Logger: logger, level: level}
+}
+
+func (l *mountLogger) enabled(level hclog.Level) bool {
+	mount := hclog.Level(l.level.Load())
+	return mount == hclog.NoLevel || level >= mount
+}
+
+func (l *mountLogger) Log(level hclog.Level, msg string, args ...interface{}) {
+	if l.enabled(level) {
+		l.Logger.Log(level, msg, args...)
+	}
+}
+
+func (l *mountLogger) Trace(msg string, args ...interface{}) { l.Log(hclog.Trace, msg, args...) }
+func (l *mountLogger) Debug(msg string, args ...interface{}) { l.Log(hclog.Debug, msg, args...) }
+func (l *mountLogger) Info(msg string, args ...interface{})  { l.Log(hclog.Info, msg, args...) }
+func (l *mountLogger) Warn(msg string, args ...interface{})  { l.Log(hclog.Warn, msg, args...) }
+func (l *mountLogger) Error(msg string, args ...interface{}) { l.Log(hclog.Error, msg, args...) }
+
+func (l *mountLogger) IsTrace() bool { return l.enabled(hclog.Trace) && l.Logger.IsTrace() }
+func (l *mountLogger) IsDebug() bool { return l.enabled(hclog.Debug) && l.Logger.IsDebug() }
+func (l *mountLogger) IsInfo() bool  { return l.enabled(hclog.Info) && l.Logger.IsInfo() }
+func (l *mountLogger) IsWarn() bool  { return l.enabled(hclog.Warn) && l.Logger.IsWarn() }
+func (l *mountLogger) IsError() bool { return l.enabled(hclog.Error) && l.Logger.IsError() }
+
+func (l *mountLogger) With(args ...interface{}) hclog.Logger {
+	return &mountLogger{Logger: l.Logger.With(args...), level: l.level}
+}
+
+func (l *mountLogger) Named(name string) hclog.Logger {
+	return &mountLogger{Logger: l.Logger.Named(name), level: l.level}
+}
+
+func (l *mountLogger) ResetNamed(name string) hclog.Logger {
+	return &mountLogger{Logger: l.Logger.ResetNamed(name), level: l.level}
+}
+
+// SetLevel sets the level of the mount only, NoLevel restores the level of Vault
+func (l *mountLogger) SetLevel(level hclog.Level) {
+	l.level.Store(int32(level))
+}
+
+func (l *mountLogger) GetLevel() hclog.Level {
+	if mount := hclog.Level(l.level.Load()); mount != hclog.NoLevel {
+		return mount
+	}
+	return l.Logger.GetLevel()
+}
+
+// applyLogLevel sets the log level of the mount from config, falling back to the level of Vault
+func (b *buddySecretBackend) applyLogLevel(config *buddyConfig) {
+	level := hclog.NoLevel
+	if config != nil && config.LogLevel != "" {
+		level = hclog.LevelFromString(config.LogLevel)
+	}
+	b.Logger().SetLevel(level)
+}
+
+// requestLogger returns the logger with the fields correlating the log lines of the request
+func (b *buddySecretBackend) requestLogger(req *logical.Request) hclog.Logger {
+	var args []interface{}
+	if req.ID != "" {
+		args = append(args, "request_id", req.ID)
+	}
+	if req.Secret != nil && req.Secret.LeaseID != "" {
+		args = append(args, "lease_id", req.Secret.LeaseID)
+	}
+	return b.Logger().With(args...)
+}
+
+// redact removes the root credentials from the message which is logged or returned to the user
+func (c *buddyConfig) redact(message string) string {
+	secrets := []string{c.Token, c.AccessToken, c.RefreshToken, c.ClientSecret, c.VaultToken}
+	for _, ws := range c.WorkspaceTokens {
+		secrets = append(secrets, ws.Token)
+	}
+	for _, secret := range secrets {
+		if secret != "" {
+			message = strings.ReplaceAll(message, secret, redactedValue)
+		}
+	}
+	return message
+}
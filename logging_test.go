@@ -0,0 +1,51 @@
+package buddysecrets
+
+import (
+	"bytes"
+	"context"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/logical"
+	"strings"
+	"testing"
+)
+
+func TestLogLevelPerMount(t *testing.T) {
+	var out bytes.Buffer
+	shared := hclog.New(&hclog.LoggerOptions{
+		Level:  hclog.Trace,
+		Output: &out,
+	})
+	mount := func() *buddySecretBackend {
+		config := logical.TestBackendConfig()
+		config.StorageView = &logical.InmemStorage{}
+		config.Logger = shared
+		b, err := Factory(context.Background(), config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			b.Cleanup(context.Background())
+		})
+		return b.(*buddySecretBackend)
+	}
+	quiet, verbose := mount(), mount()
+	quiet.applyLogLevel(&buddyConfig{LogLevel: "warn"})
+
+	quiet.Logger().With("mount", "quiet").Debug("quiet debug")
+	verbose.Logger().Debug("verbose debug")
+	if strings.Contains(out.String(), "quiet debug") {
+		t.Fatal("debug line must be dropped on the warn level")
+	}
+	if !strings.Contains(out.String(), "verbose debug") {
+		t.Fatal("log_level of one mount must not change the other mounts")
+	}
+	if shared.GetLevel() != hclog.Trace {
+		t.Fatalf("level of the shared logger must not change, got %s", shared.GetLevel())
+	}
+
+	quiet.applyLogLevel(nil)
+	quiet.Logger().Debug("restored debug")
+	if !strings.Contains(out.String(), "restored debug") {
+		t.Fatal("empty log_level must restore the level of vault")
+	}
+}
@@ -0,0 +1,79 @@
+package buddysecrets
+
+import (
+	"errors"
+	"github.com/armon/go-metrics"
+	"github.com/buddy/api-go-sdk/buddy"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// metricsStatsdAddrEnv is the statsd address the plugin process sends the metrics to
+	metricsStatsdAddrEnv = "BUDDY_STATSD_ADDR"
+	metricsPrefix        = "buddy"
+	metricsSuccess       = "success"
+	metricsFailure       = "failure"
+	metricsNoStatus      = "none"
+)
+
+// ConfigureMetrics sends the metrics of the plugin process to the statsd address set in BUDDY_STATSD_ADDR.
+// The plugin runs as a separate process, so the telemetry sink of Vault never receives them
+func ConfigureMetrics() error {
+	addr := os.Getenv(metricsStatsdAddrEnv)
+	if addr == "" {
+		return nil
+	}
+	sink, err := metrics.NewStatsdSink(addr)
+	if err != nil {
+		return err
+	}
+	conf := metrics.DefaultConfig("")
+	conf.EnableHostname = false
+	conf.EnableRuntimeMetrics = false
+	_, err = metrics.NewGlobal(conf, sink)
+	return err
+}
+
+// metricsStatus returns the label of the operation result
+func metricsStatus(err error) string {
+	if err != nil {
+		return metricsFailure
+	}
+	return metricsSuccess
+}
+
+// incrCounter increments the counter of the engine, e.g. buddy.creds.issued
+func incrCounter(name []string, labels ...metrics.Label) {
+	metrics.IncrCounterWithLabels(append([]string{metricsPrefix}, name...), 1, labels)
+}
+
+// apiStatus returns the HTTP status code of the Buddy API call, 0 if no response was received
+func apiStatus(resp *http.Response, err error) int {
+	var errResp *buddy.ErrorResponse
+	if resp != nil {
+		return resp.StatusCode
+	}
+	if errors.As(err, &errResp) && errResp.Response != nil {
+		return errResp.Response.StatusCode
+	}
+	return 0
+}
+
+// observeApiCall records the latency and the HTTP status of the Buddy API call
+func observeApiCall(operation string, start time.Time, resp *http.Response, err error) {
+	status := metricsNoStatus
+	if code := apiStatus(resp, err); code != 0 {
+		status = strconv.Itoa(code)
+	}
+	labels := []metrics.Label{
+		{Name: "operation", Value: operation},
+		{Name: "status", Value: status},
+	}
+	metrics.MeasureSinceWithLabels([]string{metricsPrefix, "api", "request"}, start, labels)
+	if err != nil {
+		metrics.IncrCounterWithLabels([]string{metricsPrefix, "api", "error"}, 1, labels)
+	}
+}
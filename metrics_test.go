@@ -0,0 +1,98 @@
+package buddysecrets
+
+import (
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/sdk/logical"
+	"testing"
+	"time"
+)
+
+// testMetrics replaces the global sink with the in-memory one for the test
+func testMetrics(t *testing.T) *metrics.InmemSink {
+	t.Helper()
+	sink := metrics.NewInmemSink(time.Hour, time.Hour)
+	conf := metrics.DefaultConfig("")
+	conf.EnableHostname = false
+	conf.EnableRuntimeMetrics = false
+	if _, err := metrics.NewGlobal(conf, sink); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_, _ = metrics.NewGlobal(metrics.DefaultConfig(""), &metrics.BlackholeSink{})
+	})
+	return sink
+}
+
+// counterValue returns the sum of the counter with the name and the labels
+func counterValue(sink *metrics.InmemSink, name string, labels ...metrics.Label) int {
+	key := name
+	for _, label := range labels {
+		key += ";" + label.Name + "=" + label.Value
+	}
+	count := 0
+	for _, interval := range sink.Data() {
+		interval.RLock()
+		if counter, ok := interval.Counters[key]; ok {
+			count += counter.Count
+		}
+		interval.RUnlock()
+	}
+	return count
+}
+
+func TestRevokeCounters(t *testing.T) {
+	sink := testMetrics(t)
+	f := newFakeBuddy(t)
+	b, s := getTestBackend(t, 0)
+	testConfigure(t, b, s, f)
+	testOk(t, b, s, logical.CreateOperation, "roles/r1", map[string]interface{}{
+		"scopes": "WORKSPACE",
+	})
+	resp := testOk(t, b, s, logical.ReadOperation, "creds/r1", nil)
+	if err := testRevoke(t, b, s, resp.Secret); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, secretType := range []string{SecretTypeIntegration, SecretTypeElevation} {
+		// the lease without the workspace can not be revoked
+		err := testRevoke(t, b, s, &logical.Secret{
+			InternalData: map[string]interface{}{
+				"secret_type": secretType,
+				"role":        "r2",
+			},
+		})
+		if err == nil {
+			t.Fatalf("%s: revocation must fail", secretType)
+		}
+	}
+
+	failed := []metrics.Label{{Name: "role", Value: "r2"}, {Name: "status", Value: metricsFailure}}
+	counters := []struct {
+		name   string
+		labels []metrics.Label
+	}{
+		{name: "buddy.creds.issued", labels: []metrics.Label{{Name: "role", Value: "r1"}}},
+		{name: "buddy.creds.revoked", labels: []metrics.Label{{Name: "role", Value: "r1"}, {Name: "status", Value: metricsSuccess}}},
+		{name: "buddy.integration.revoked", labels: failed},
+		{name: "buddy.elevation.revoked", labels: failed},
+	}
+	for _, counter := range counters {
+		if got := counterValue(sink, counter.name, counter.labels...); got != 1 {
+			t.Errorf("%s %v: expected 1, got %d", counter.name, counter.labels, got)
+		}
+	}
+}
+
+func TestConfigureMetrics(t *testing.T) {
+	t.Setenv(metricsStatsdAddrEnv, "")
+	if err := ConfigureMetrics(); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(metricsStatsdAddrEnv, "127.0.0.1:8125")
+	if err := ConfigureMetrics(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_, _ = metrics.NewGlobal(metrics.DefaultConfig(""), &metrics.BlackholeSink{})
+	})
+}
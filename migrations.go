@@ -0,0 +1,225 @@
+package buddysecrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/vault/sdk/helper/jsonutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	schemaVersionKey = "schema_version"
+)
+
+// migration upgrades the raw storage entry to the next schema version. Entries written before
+// the schema versions were introduced have version 0
+type migration struct {
+	version int
+	migrate func(raw map[string]interface{}) error
+}
+
+// configMigrations upgrade buddyConfig, ordered by version
+var configMigrations = []migration{
+	{
+		// defaults were applied on write only
+		version: 1,
+		migrate: func(raw map[string]interface{}) error {
+			if baseUrl, _ := raw["base_url"].(string); baseUrl == "" {
+				raw["base_url"] = defaultBaseUrl
+			}
+			ttl, err := rawInt(raw["token_ttl_in_days"])
+			if err != nil {
+				return err
+			}
+			if ttl <= 0 {
+				raw["token_ttl_in_days"] = defaultRootTokenTTL
+			}
+			return nil
+		},
+	},
+}
+
+// roleMigrations upgrade roleEntry, ordered by version
+var roleMigrations = []migration{
+	{
+		// roles written before the version history have no max_versions
+		version: 1,
+		migrate: func(raw map[string]interface{}) error {
+			maxVersions, err := rawInt(raw["max_versions"])
+			if err != nil {
+				return err
+			}
+			if maxVersions <= 0 {
+				raw["max_versions"] = roleDefaultMaxVersions
+			}
+			for _, key := range []string{"scopes", "ip_restrictions", "workspace_restrictions"} {
+				if raw[key] == nil {
+					raw[key] = []string{}
+				}
+			}
+			return nil
+		},
+	},
+	{
+		// roles written before the delivery modes returned the token in the response
+		version: 2,
+		migrate: func(raw map[string]interface{}) error {
+			if delivery, _ := raw["delivery"].(string); delivery == "" {
+				raw["delivery"] = roleDeliveryDirect
+			}
+			return nil
+		},
+	},
+}
+
+var configSchemaVersion = latestSchemaVersion(configMigrations)
+var roleSchemaVersion = latestSchemaVersion(roleMigrations)
+
+func latestSchemaVersion(migrations []migration) int {
+	return migrations[len(migrations)-1].version
+}
+
+// rawInt returns the integer of the raw entry decoded with json numbers, 0 if missing
+func rawInt(value interface{}) (int, error) {
+	switch v := value.(type) {
+	case nil:
+		return 0, nil
+	case json.Number:
+		n, err := v.Int64()
+		return int(n), err
+	case int:
+		return v, nil
+	default:
+		return 0, fmt.Errorf("unexpected number %v", value)
+	}
+}
+
+// migrateRaw runs the migrations newer than the schema version of the raw entry and reports
+// whether anything was changed
+func migrateRaw(raw map[string]interface{}, migrations []migration) (bool, error) {
+	version, err := rawInt(raw[schemaVersionKey])
+	if err != nil {
+		return false, err
+	}
+	if latest := latestSchemaVersion(migrations); version > latest {
+		return false, fmt.Errorf("schema version %d is newer than the supported version %d, upgrade the plugin", version, latest)
+	}
+	migrated := false
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+		if err := m.migrate(raw); err != nil {
+			return false, fmt.Errorf("migrating to schema version %d: %w", m.version, err)
+		}
+		raw[schemaVersionKey] = m.version
+		migrated = true
+	}
+	return migrated, nil
+}
+
+// decodeEntry decodes the storage entry into out, upgrading it to the current schema version
+// in memory. The upgraded entries are persisted by migrateStorage
+func decodeEntry(entry *logical.StorageEntry, migrations []migration, out interface{}) (bool, error) {
+	raw := map[string]interface{}{}
+	if err := entry.DecodeJSON(&raw); err != nil {
+		return false, err
+	}
+	migrated, err := migrateRaw(raw, migrations)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", entry.Key, err)
+	}
+	return migrated, remarshal(raw, out)
+}
+
+func remarshal(raw map[string]interface{}, out interface{}) error {
+	buf, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	return jsonutil.DecodeJSON(buf, out)
+}
+
+// migrateStorage upgrades the stored config, roles and role versions to the current schema
+// versions. It runs when the mount is initialized on the node which can write the storage
+func (b *buddySecretBackend) migrateStorage(ctx context.Context, s logical.Storage) error {
+	if !b.sharedStorageWritable() {
+		return nil
+	}
+	migratedConfig := 0
+	entry, err := s.Get(ctx, configStoragePath)
+	if err != nil {
+		return err
+	}
+	if entry != nil {
+		config := new(buddyConfig)
+		migrated, err := decodeEntry(entry, configMigrations, config)
+		if err != nil {
+			return err
+		}
+		if migrated {
+			if err := putConfig(ctx, config, s); err != nil {
+				return err
+			}
+			migratedConfig++
+		}
+	}
+	names, err := s.List(ctx, rolesStoragePath+"/")
+	if err != nil {
+		return err
+	}
+	migratedRoles, migratedVersions := 0, 0
+	for _, name := range names {
+		entry, err := s.Get(ctx, fmt.Sprintf("%s/%s", rolesStoragePath, name))
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			continue
+		}
+		role := new(roleEntry)
+		migrated, err := decodeEntry(entry, roleMigrations, role)
+		if err != nil {
+			return err
+		}
+		if migrated {
+			if err := saveRole(ctx, s, role, name); err != nil {
+				return err
+			}
+			migratedRoles++
+		}
+		versions, err := listRoleVersions(ctx, s, name)
+		if err != nil {
+			return err
+		}
+		for _, version := range versions {
+			entry, err := s.Get(ctx, roleVersionPath(name, version))
+			if err != nil {
+				return err
+			}
+			if entry == nil {
+				continue
+			}
+			v, migrated, err := decodeRoleVersion(entry)
+			if err != nil {
+				return err
+			}
+			if !migrated {
+				continue
+			}
+			entry, err = logical.StorageEntryJSON(roleVersionPath(name, version), v)
+			if err != nil {
+				return err
+			}
+			if err := s.Put(ctx, entry); err != nil {
+				return err
+			}
+			migratedVersions++
+		}
+	}
+	if migratedConfig+migratedRoles+migratedVersions > 0 {
+		b.Logger().Info("migrated storage to the current schema version", "config", migratedConfig, "roles", migratedRoles, "role_versions", migratedVersions)
+	}
+	return nil
+}
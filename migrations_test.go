@@ -0,0 +1,202 @@
+package buddysecrets
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/logical"
+	"testing"
+)
+
+// baseline entries are written by the plugin versions before the schema versions
+var baselineEntries = map[string]string{
+	configStoragePath:        `{"token":"root","base_url":"","insecure":false,"token_auto_rotate":false,"token_ttl_in_days":0,"token_id":"root-id"}`,
+	rolesStoragePath + "/r1": `{"ttl":3600000000000,"max_ttl":0,"scopes":["WORKSPACE"],"ip_restrictions":null,"workspace_restrictions":null}`,
+	roleVersionPath("r1", 1): `{"version":1,"role":{"ttl":3600000000000,"max_ttl":0,"scopes":["WORKSPACE"],"ip_restrictions":null,"workspace_restrictions":null},"created_by":"root"}`,
+	rolesStoragePath + "/r2": `{"ttl":0,"max_ttl":0,"scopes":null,"ip_restrictions":["10.0.0.0/8"],"workspace_restrictions":["acme"]}`,
+	rolesStoragePath + "/r3": `{"schema_version":1,"ttl":0,"max_ttl":0,"scopes":["WORKSPACE"],"max_versions":3}`,
+	roleVersionPath("r3", 2): `{"version":2,"role":{"schema_version":1,"scopes":["WORKSPACE"],"max_versions":3}}`,
+}
+
+func putRawEntries(t *testing.T, s logical.Storage, entries map[string]string) {
+	t.Helper()
+	for key, value := range entries {
+		if err := s.Put(context.Background(), &logical.StorageEntry{Key: key, Value: []byte(value)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func getRawEntry(t *testing.T, s logical.Storage, key string) map[string]interface{} {
+	t.Helper()
+	entry, err := s.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry == nil {
+		t.Fatalf("entry %s not found", key)
+	}
+	raw := map[string]interface{}{}
+	if err := entry.DecodeJSON(&raw); err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestInitializeMigratesStorage(t *testing.T) {
+	b, s := getTestBackend(t, 0)
+	putRawEntries(t, s, baselineEntries)
+	if err := b.Initialize(context.Background(), &logical.InitializationRequest{Storage: s}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key string
+		// role is the key of the role within the entry, empty for the entry itself
+		role string
+		want map[string]interface{}
+	}{
+		{
+			key: configStoragePath,
+			want: map[string]interface{}{
+				schemaVersionKey:    configSchemaVersion,
+				"base_url":          defaultBaseUrl,
+				"token_ttl_in_days": defaultRootTokenTTL,
+				"token":             "root",
+				"token_id":          "root-id",
+			},
+		},
+		{
+			key: rolesStoragePath + "/r1",
+			want: map[string]interface{}{
+				schemaVersionKey:         roleSchemaVersion,
+				"max_versions":           roleDefaultMaxVersions,
+				"delivery":               roleDeliveryDirect,
+				"scopes":                 []string{"WORKSPACE"},
+				"ip_restrictions":        []string{},
+				"workspace_restrictions": []string{},
+				"ttl":                    3600000000000,
+			},
+		},
+		{
+			key:  roleVersionPath("r1", 1),
+			role: "role",
+			want: map[string]interface{}{
+				schemaVersionKey: roleSchemaVersion,
+				"max_versions":   roleDefaultMaxVersions,
+				"delivery":       roleDeliveryDirect,
+				"scopes":         []string{"WORKSPACE"},
+			},
+		},
+		{
+			key: rolesStoragePath + "/r2",
+			want: map[string]interface{}{
+				schemaVersionKey:         roleSchemaVersion,
+				"scopes":                 []string{},
+				"ip_restrictions":        []string{"10.0.0.0/8"},
+				"workspace_restrictions": []string{"acme"},
+			},
+		},
+		{
+			// only the migrations newer than the stored schema version run
+			key: rolesStoragePath + "/r3",
+			want: map[string]interface{}{
+				schemaVersionKey: roleSchemaVersion,
+				"max_versions":   3,
+				"delivery":       roleDeliveryDirect,
+			},
+		},
+		{
+			key:  roleVersionPath("r3", 2),
+			role: "role",
+			want: map[string]interface{}{
+				schemaVersionKey: roleSchemaVersion,
+				"max_versions":   3,
+				"delivery":       roleDeliveryDirect,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			raw := getRawEntry(t, s, tt.key)
+			if tt.role != "" {
+				raw = raw[tt.role].(map[string]interface{})
+			}
+			for key, want := range tt.want {
+				if got := fmt.Sprint(raw[key]); got != fmt.Sprint(want) {
+					t.Errorf("%s: expected %v, got %v", key, want, got)
+				}
+			}
+		})
+	}
+
+	// the entries are read in the current schema without migrating them again
+	role, err := getRole(context.Background(), "r1", s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role.SchemaVersion != roleSchemaVersion || role.MaxVersions != roleDefaultMaxVersions || role.Delivery != roleDeliveryDirect {
+		t.Fatalf("unexpected migrated role %+v", role)
+	}
+}
+
+func TestInitializeMigratesNothingOnPerformanceSecondary(t *testing.T) {
+	states := map[string]consts.ReplicationState{
+		"secondary": consts.ReplicationPerformanceSecondary,
+		"standby":   consts.ReplicationPerformanceStandby,
+	}
+	for name, state := range states {
+		t.Run(name, func(t *testing.T) {
+			b, s := getTestBackend(t, state)
+			putRawEntries(t, s, baselineEntries)
+			if err := b.Initialize(context.Background(), &logical.InitializationRequest{Storage: s}); err != nil {
+				t.Fatal(err)
+			}
+			for key, value := range baselineEntries {
+				entry, err := s.Get(context.Background(), key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(entry.Value) != value {
+					t.Errorf("%s must not be written, got %s", key, entry.Value)
+				}
+			}
+			// the entries are still migrated in memory when read
+			config, err := b.getConfig(context.Background(), s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.BaseUrl != defaultBaseUrl {
+				t.Fatalf("expected the default base_url, got %s", config.BaseUrl)
+			}
+		})
+	}
+}
+
+func TestInitializeRejectsNewerSchemaVersion(t *testing.T) {
+	tests := map[string]string{
+		configStoragePath:        fmt.Sprintf(`{"schema_version":%d,"token":"root"}`, configSchemaVersion+1),
+		rolesStoragePath + "/r1": fmt.Sprintf(`{"schema_version":%d,"scopes":["WORKSPACE"]}`, roleSchemaVersion+1),
+		roleVersionPath("r1", 1): fmt.Sprintf(`{"version":1,"role":{"schema_version":%d}}`, roleSchemaVersion+1),
+	}
+	for key, value := range tests {
+		t.Run(key, func(t *testing.T) {
+			b, s := getTestBackend(t, 0)
+			entries := map[string]string{key: value}
+			if key == roleVersionPath("r1", 1) {
+				entries[rolesStoragePath+"/r1"] = `{"scopes":["WORKSPACE"]}`
+			}
+			putRawEntries(t, s, entries)
+			if err := b.Initialize(context.Background(), &logical.InitializationRequest{Storage: s}); err == nil {
+				t.Fatal("newer schema version must be rejected")
+			}
+			entry, err := s.Get(context.Background(), key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(entry.Value) != value {
+				t.Fatalf("%s must not be written, got %s", key, entry.Value)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package buddysecrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	oauthTokenPath = "/oauth2/token"
+	// access token is refreshed when it expires in less than this margin
+	oauthAccessTokenMargin = 5 * time.Minute
+	// refresh token is exchanged at least once per this interval, so it never goes stale
+	oauthRefreshInterval = 24 * time.Hour
+	oauthTimeout         = 30 * time.Second
+)
+
+type oauthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+func (c *buddyConfig) usesOAuth() bool {
+	return c.ClientId != ""
+}
+
+func (c *buddyConfig) hasRootCredential() bool {
+	if c.usesIdentityToken() {
+		return true
+	}
+	if c.usesOAuth() {
+		return c.ClientSecret != "" && c.RefreshToken != ""
+	}
+	return c.Token != ""
+}
+
+func (c *buddyConfig) accessTokenValid() bool {
+	return c.AccessToken != "" && time.Now().Add(oauthAccessTokenMargin).Before(c.AccessTokenExpiresAt)
+}
+
+// refreshAccessToken exchanges the refresh token of the OAuth application for a new access token.
+// Buddy may return a new refresh token, in which case it replaces the old one in config
+func refreshAccessToken(config *buddyConfig) error {
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("client_id", config.ClientId)
+	form.Set("client_secret", config.ClientSecret)
+	form.Set("refresh_token", config.RefreshToken)
+	tokenResp, err := requestAccessToken(config, form)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	config.AccessToken = tokenResp.AccessToken
+	config.AccessTokenExpiresAt = now.Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
+	config.RefreshedAt = now
+	if tokenResp.RefreshToken != "" {
+		config.RefreshToken = tokenResp.RefreshToken
+	}
+	return nil
+}
+
+// requestAccessToken calls the Buddy OAuth token endpoint with the given grant
+func requestAccessToken(config *buddyConfig, form url.Values) (*oauthTokenResponse, error) {
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(config.BaseUrl, "/")+oauthTokenPath, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	h := newHttpClient(config.Insecure, oauthTimeout)
+	res, err := h.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to obtain oauth access token: %d", res.StatusCode)
+	}
+	tokenResp := new(oauthTokenResponse)
+	if err := json.NewDecoder(res.Body).Decode(tokenResp); err != nil {
+		return nil, err
+	}
+	if tokenResp.AccessToken == "" {
+		return nil, fmt.Errorf("unable to obtain oauth access token: empty response")
+	}
+	return tokenResp, nil
+}
@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"github.com/buddy/api-go-sdk/buddy"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/pluginidentityutil"
 	"github.com/hashicorp/vault/sdk/logical"
+	"slices"
+	"strconv"
 	"time"
 )
 
@@ -32,45 +35,104 @@ type buddyConfig struct {
 	TokenScopes                []string  `json:"token_scopes"`
 	TokenIpRestrictions        []string  `json:"token_ip_restrictions"`
 	TokenWorkspaceRestrictions []string  `json:"token_workspace_restrictions"`
+	VaultAddr                  string    `json:"vault_addr"`
+	VaultToken                 string    `json:"vault_token"`
+	CredentialsPathPrefixes    []string  `json:"credentials_path_prefixes"`
+	ClientId                   string    `json:"client_id"`
+	ClientSecret               string    `json:"client_secret"`
+	RefreshToken               string    `json:"refresh_token"`
+	AccessToken                string    `json:"access_token"`
+	AccessTokenExpiresAt       time.Time `json:"access_token_expires_at"`
+	RefreshedAt                time.Time `json:"refreshed_at"`
+	LogLevel                   string    `json:"log_level"`
+	SchemaVersion              int       `json:"schema_version"`
+	// WorkspaceTokens are the root tokens of the workspaces by workspace domain
+	WorkspaceTokens map[string]*workspaceRootToken `json:"workspace_tokens"`
+
+	pluginidentityutil.PluginIdentityTokenParams
 }
 
 func pathConfig(b *buddySecretBackend) *framework.Path {
+	fields := map[string]*framework.FieldSchema{
+		"token": {
+			Type:        framework.TypeString,
+			Description: "The Personal Access Token (root token) generated in Buddy. Must have the scope `TOKEN_MANAGE`. Required unless `client_id` or `identity_token_audience` is set",
+		},
+		"token_ttl_in_days": {
+			Type:        framework.TypeInt,
+			Description: fmt.Sprintf("The lease time of the rotated root token in days. Default: %d. Min: %d", defaultRootTokenTTL, minRootTokenTTL),
+		},
+		"token_auto_rotate": {
+			Type:        framework.TypeBool,
+			Description: "Enables auto-rotation of the root token one day before the expiration date. If an error is encountered, the plugin will reattempt to rotate the token on every hour until it eventually expires.",
+		},
+		"base_url": {
+			Type:        framework.TypeString,
+			Description: fmt.Sprintf("The Buddy API base URL. You may need to set this in your Buddy On-Premises API endpoint. Default: `%s`", defaultBaseUrl),
+		},
+		"insecure": {
+			Type:        framework.TypeBool,
+			Description: "Disables the SSL verification of the API calls. You may need to set this to true if you are using Buddy On-Premises without a signed certificate. Default: false",
+		},
+		"client_id": {
+			Type:        framework.TypeString,
+			Description: "The client ID of the Buddy OAuth application used as root credential instead of `token`. The application must have the scope `TOKEN_MANAGE`",
+		},
+		"client_secret": {
+			Type:        framework.TypeString,
+			Description: "The client secret of the Buddy OAuth application",
+		},
+		"refresh_token": {
+			Type:        framework.TypeString,
+			Description: "The refresh token of the Buddy OAuth application. It is exchanged for access tokens automatically and rotated periodically",
+		},
+		"vault_addr": {
+			Type:        framework.TypeString,
+			Description: "The address of the Vault server used to read integration credentials from other Vault paths. Default: `VAULT_ADDR` environment variable",
+		},
+		"workspace_tokens": {
+			Type:        framework.TypeKVPairs,
+			Description: "The root tokens of the Buddy workspaces as workspace domain=token pairs, e.g. `workspace_tokens=acme=TOKEN`. Each token must have the scope `TOKEN_MANAGE` and be restricted to its workspace. The tokens of the roles with `workspace_restrictions` are issued by the root token of their workspace",
+		},
+		"workspace_token_auto_rotate": {
+			Type:        framework.TypeKVPairs,
+			Description: "Enables auto-rotation of the workspace root tokens as workspace domain=true|false pairs, e.g. `workspace_token_auto_rotate=acme=true`. The workspaces not given keep their setting, the new ones are not rotated",
+		},
+		"log_level": {
+			Type:        framework.TypeString,
+			Description: "The log level of the mount: trace, debug, info, warn or error. Vault drops the lines below its own log level. Default: the log level of Vault",
+		},
+		"vault_token": {
+			Type:        framework.TypeString,
+			Description: "The Vault token used to read integration credentials from other Vault paths. Needs `read` on the `credentials_path_prefixes`. Also used by `revoke-all` to revoke the role leases, which needs `update` and `sudo` on `sys/leases/revoke-prefix/<mount>/creds/*`",
+		},
+		"credentials_path_prefixes": {
+			Type:        framework.TypeCommaStringSlice,
+			Description: "The Vault path prefixes from which the integration roles can read their credentials, comma-separated, e.g. `secret/data/buddy/`. The `credentials_path` outside of them is refused. Default: none",
+		},
+	}
+	pluginidentityutil.AddPluginIdentityTokenFields(fields)
 	return &framework.Path{
 		Pattern: "config",
-		Fields: map[string]*framework.FieldSchema{
-			"token": {
-				Type:        framework.TypeString,
-				Description: "The Personal Access Token (root token) generated in Buddy. Must have the scope `TOKEN_MANAGE`. Required",
-			},
-			"token_ttl_in_days": {
-				Type:        framework.TypeInt,
-				Description: fmt.Sprintf("The lease time of the rotated root token in days. Default: %d. Min: %d", defaultRootTokenTTL, minRootTokenTTL),
-			},
-			"token_auto_rotate": {
-				Type:        framework.TypeBool,
-				Description: "Enables auto-rotation of the root token one day before the expiration date. If an error is encountered, the plugin will reattempt to rotate the token on every hour until it eventually expires.",
-			},
-			"base_url": {
-				Type:        framework.TypeString,
-				Description: fmt.Sprintf("The Buddy API base URL. You may need to set this in your Buddy On-Premises API endpoint. Default: `%s`", defaultBaseUrl),
-			},
-			"insecure": {
-				Type:        framework.TypeBool,
-				Description: "Disables the SSL verification of the API calls. You may need to set this to true if you are using Buddy On-Premises without a signed certificate. Default: false",
-			},
-		},
+		Fields:  fields,
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.ReadOperation: &framework.PathOperation{
 				Callback: b.pathConfigRead,
 			},
 			logical.CreateOperation: &framework.PathOperation{
-				Callback: b.pathConfigWrite,
+				Callback:                    b.pathConfigWrite,
+				ForwardPerformanceSecondary: true,
+				ForwardPerformanceStandby:   true,
 			},
 			logical.UpdateOperation: &framework.PathOperation{
-				Callback: b.pathConfigWrite,
+				Callback:                    b.pathConfigWrite,
+				ForwardPerformanceSecondary: true,
+				ForwardPerformanceStandby:   true,
 			},
 			logical.DeleteOperation: &framework.PathOperation{
-				Callback: b.pathConfigDelete,
+				Callback:                    b.pathConfigDelete,
+				ForwardPerformanceSecondary: true,
+				ForwardPerformanceStandby:   true,
 			},
 		},
 		ExistenceCheck:  b.pathConfigExistenceCheck,
@@ -89,6 +151,10 @@ func hasManageScope(scopes []string) bool {
 }
 
 func (b *buddySecretBackend) pathConfigWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	// the oauth refresh token may be exchanged while validating the config, which must not race with
+	// the refresh of getClient and rotateRootToken
+	b.lock.Lock()
+	defer b.lock.Unlock()
 	config, err := b.getConfig(ctx, req.Storage)
 	if err != nil {
 		return nil, err
@@ -114,6 +180,74 @@ func (b *buddySecretBackend) pathConfigWrite(ctx context.Context, req *logical.R
 	if tokenTTL, ok := data.GetOk("token_ttl_in_days"); ok {
 		config.TokenTtlInDays = tokenTTL.(int)
 	}
+	if clientId, ok := data.GetOk("client_id"); ok {
+		config.ClientId = clientId.(string)
+	}
+	if clientSecret, ok := data.GetOk("client_secret"); ok {
+		config.ClientSecret = clientSecret.(string)
+	}
+	if refreshToken, ok := data.GetOk("refresh_token"); ok {
+		config.RefreshToken = refreshToken.(string)
+		// new refresh token invalidates the cached access token
+		config.AccessToken = ""
+	}
+	if err := config.ParsePluginIdentityTokenFields(data); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if vaultAddr, ok := data.GetOk("vault_addr"); ok {
+		config.VaultAddr = vaultAddr.(string)
+	}
+	if vaultToken, ok := data.GetOk("vault_token"); ok {
+		config.VaultToken = vaultToken.(string)
+	}
+	if prefixes, ok := data.GetOk("credentials_path_prefixes"); ok {
+		config.CredentialsPathPrefixes = prefixes.([]string)
+	}
+	if workspaceTokens, ok := data.GetOk("workspace_tokens"); ok {
+		tokens := workspaceTokens.(map[string]string)
+		// the tokens are deleted by the root token of the workspace which created them
+		for _, domain := range config.workspaceDomains() {
+			if _, ok := tokens[domain]; ok {
+				continue
+			}
+			inUse, err := workspaceInUse(ctx, req.Storage, domain)
+			if err != nil {
+				return nil, err
+			}
+			if inUse {
+				return logical.ErrorResponse("root token of workspace '%s' cannot be removed while its tokens are issued or pooled, revoke them first", domain), nil
+			}
+		}
+		previous := config.WorkspaceTokens
+		config.WorkspaceTokens = map[string]*workspaceRootToken{}
+		for domain, token := range tokens {
+			ws := &workspaceRootToken{
+				Token: token,
+			}
+			if previous[domain] != nil {
+				ws.TokenAutoRotate = previous[domain].TokenAutoRotate
+			}
+			config.WorkspaceTokens[domain] = ws
+		}
+	}
+	if autoRotate, ok := data.GetOk("workspace_token_auto_rotate"); ok {
+		for domain, value := range autoRotate.(map[string]string) {
+			ws := config.WorkspaceTokens[domain]
+			if ws == nil {
+				return logical.ErrorResponse("workspace_token_auto_rotate: root token of workspace '%s' is not configured", domain), nil
+			}
+			ws.TokenAutoRotate, err = strconv.ParseBool(value)
+			if err != nil {
+				return logical.ErrorResponse("workspace_token_auto_rotate: invalid value '%s' of workspace '%s'", value, domain), nil
+			}
+		}
+	}
+	if logLevel, ok := data.GetOk("log_level"); ok {
+		config.LogLevel, err = parseLogLevel(logLevel.(string))
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
 	if config.BaseUrl == "" {
 		config.BaseUrl = defaultBaseUrl
 	}
@@ -123,16 +257,58 @@ func (b *buddySecretBackend) pathConfigWrite(ctx context.Context, req *logical.R
 	if config.TokenTtlInDays < minRootTokenTTL {
 		return logical.ErrorResponse("token ttl must be at least %d days", minRootTokenTTL), nil
 	}
-	if config.Token == "" {
+	if config.usesIdentityToken() {
+		if config.Token != "" || config.usesOAuth() {
+			return logical.ErrorResponse("token and client_id cannot be set together with identity_token_audience"), nil
+		}
+		if config.TokenAutoRotate {
+			return logical.ErrorResponse("token_auto_rotate is not supported with identity_token_audience"), nil
+		}
+	} else if config.usesOAuth() {
+		if config.Token != "" {
+			return logical.ErrorResponse("token cannot be set together with client_id"), nil
+		}
+		if config.ClientSecret == "" || config.RefreshToken == "" {
+			return logical.ErrorResponse("client_secret and refresh_token must be provided with client_id"), nil
+		}
+		if config.TokenAutoRotate {
+			return logical.ErrorResponse("token_auto_rotate is not supported with client_id"), nil
+		}
+	} else if config.Token == "" {
 		return logical.ErrorResponse("token must be provided"), nil
 	}
-	client, err := b.getNewClient(config)
+	if err := b.inspectRootToken(ctx, config); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	rootTokenIds := map[string]bool{config.TokenId: true}
+	for _, domain := range config.workspaceDomains() {
+		wc := config.forWorkspace(domain)
+		if err := b.inspectRootToken(ctx, wc); err != nil {
+			return logical.ErrorResponse("workspace '%s' root token: %s", domain, err), nil
+		}
+		if !slices.Contains(wc.TokenWorkspaceRestrictions, domain) {
+			return logical.ErrorResponse("workspace '%s' root token must be restricted to the workspace", domain), nil
+		}
+		// the same token rotated twice would be deleted by the other rotation
+		if rootTokenIds[wc.TokenId] {
+			return logical.ErrorResponse("workspace '%s' root token is already used by another workspace or the root credentials", domain), nil
+		}
+		rootTokenIds[wc.TokenId] = true
+		config.putWorkspace(domain, wc)
+	}
+	err = b.saveConfigLocked(ctx, config, req.Storage)
+	return nil, err
+}
+
+// inspectRootToken reads the root token of config and stores its expiration, rotation date, scopes and restrictions
+func (b *buddySecretBackend) inspectRootToken(ctx context.Context, config *buddyConfig) error {
+	client, err := b.getNewClient(ctx, config)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("unable to authenticate: %s", config.redact(err.Error()))
 	}
 	token, err := client.GetRootToken()
 	if err != nil {
-		return logical.ErrorResponse("invalid token"), nil
+		return fmt.Errorf("invalid token")
 	}
 	expiresAt, expiresAtErr := time.Parse(time.RFC3339, token.ExpiresAt)
 	if config.TokenAutoRotate {
@@ -142,7 +318,7 @@ func (b *buddySecretBackend) pathConfigWrite(ctx context.Context, req *logical.R
 		if expiresAtErr == nil && expiresAt.Unix() < rotateAt.Unix() {
 			rotateAt = time.Date(expiresAt.Year(), expiresAt.Month(), expiresAt.Day()-1, expiresAt.Hour(), expiresAt.Minute(), expiresAt.Second(), expiresAt.Nanosecond(), expiresAt.Location())
 			if rotateAt.Unix() < minExpirationDate.Unix() {
-				return logical.ErrorResponse("token expiration date must be set after %s, instead it expires at: %s", minExpirationDate.Format(time.RFC3339), expiresAt.Format(time.RFC3339)), nil
+				return fmt.Errorf("token expiration date must be set after %s, instead it expires at: %s", minExpirationDate.Format(time.RFC3339), expiresAt.Format(time.RFC3339))
 			}
 		}
 		config.TokenAutoRotateAt = rotateAt
@@ -154,10 +330,21 @@ func (b *buddySecretBackend) pathConfigWrite(ctx context.Context, req *logical.R
 	config.TokenIpRestrictions = token.IpRestrictions
 	config.TokenWorkspaceRestrictions = token.WorkspaceRestrictions
 	if !hasManageScope(config.TokenScopes) {
-		return logical.ErrorResponse("token must have `%s` scope", buddy.TokenScopeTokenManage), nil
+		return fmt.Errorf("token must have `%s` scope", buddy.TokenScopeTokenManage)
+	}
+	return nil
+}
+
+// zeroSecrets removes the root credentials from config which is read for its settings only
+func (c *buddyConfig) zeroSecrets() {
+	c.Token = ""
+	c.ClientSecret = ""
+	c.RefreshToken = ""
+	c.AccessToken = ""
+	c.VaultToken = ""
+	for _, ws := range c.WorkspaceTokens {
+		ws.Token = ""
 	}
-	err = b.saveConfig(ctx, config, req.Storage)
-	return nil, err
 }
 
 func (b *buddySecretBackend) pathConfigRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
@@ -168,14 +355,24 @@ func (b *buddySecretBackend) pathConfigRead(ctx context.Context, req *logical.Re
 	if config == nil {
 		config = new(buddyConfig)
 	}
+	config.zeroSecrets()
 	resp := &logical.Response{
 		Data: map[string]interface{}{
-			"base_url":          config.BaseUrl,
-			"insecure":          config.Insecure,
-			"token_ttl_in_days": config.TokenTtlInDays,
-			"token_auto_rotate": config.TokenAutoRotate,
+			"base_url":                  config.BaseUrl,
+			"insecure":                  config.Insecure,
+			"token_ttl_in_days":         config.TokenTtlInDays,
+			"token_auto_rotate":         config.TokenAutoRotate,
+			"vault_addr":                config.VaultAddr,
+			"credentials_path_prefixes": config.CredentialsPathPrefixes,
+			"log_level":                 config.LogLevel,
 		},
 	}
+	config.PopulatePluginIdentityTokenData(resp.Data)
+	if config.usesOAuth() {
+		resp.Data["client_id"] = config.ClientId
+		resp.Data["access_token_expires_at"] = config.AccessTokenExpiresAt
+		resp.Data["refreshed_at"] = config.RefreshedAt
+	}
 	if config.TokenAutoRotate {
 		resp.Data["token_auto_rotate_at"] = config.TokenAutoRotateAt
 	}
@@ -190,6 +387,28 @@ func (b *buddySecretBackend) pathConfigRead(ctx context.Context, req *logical.Re
 		resp.Data["token_ip_restrictions"] = config.TokenIpRestrictions
 		resp.Data["token_workspace_restrictions"] = config.TokenWorkspaceRestrictions
 	}
+	if len(config.WorkspaceTokens) > 0 {
+		workspaces := map[string]interface{}{}
+		for domain, ws := range config.WorkspaceTokens {
+			workspace := map[string]interface{}{
+				"token_id":                     ws.TokenId,
+				"token_scopes":                 ws.TokenScopes,
+				"token_ip_restrictions":        ws.TokenIpRestrictions,
+				"token_workspace_restrictions": ws.TokenWorkspaceRestrictions,
+			}
+			if ws.TokenNoExpiration {
+				workspace["token_expires_at"] = "no expiration date"
+			} else {
+				workspace["token_expires_at"] = ws.TokenExpiresAt
+			}
+			workspace["token_auto_rotate"] = ws.TokenAutoRotate
+			if ws.TokenAutoRotate {
+				workspace["token_auto_rotate_at"] = ws.TokenAutoRotateAt
+			}
+			workspaces[domain] = workspace
+		}
+		resp.Data["workspace_tokens"] = workspaces
+	}
 	return resp, nil
 }
 
@@ -197,6 +416,7 @@ func (b *buddySecretBackend) pathConfigDelete(ctx context.Context, req *logical.
 	err := req.Storage.Delete(ctx, configStoragePath)
 	if err == nil {
 		b.reset()
+		b.applyLogLevel(nil)
 	}
 	return nil, err
 }
@@ -218,24 +438,45 @@ func (b *buddySecretBackend) getConfig(ctx context.Context, s logical.Storage) (
 		return nil, nil
 	}
 	config := new(buddyConfig)
-	if err := entry.DecodeJSON(config); err != nil {
+	if _, err := decodeEntry(entry, configMigrations, config); err != nil {
 		return nil, err
 	}
 	return config, nil
 }
 
 func (b *buddySecretBackend) saveConfig(ctx context.Context, config *buddyConfig, s logical.Storage) error {
-	entry, err := logical.StorageEntryJSON(configStoragePath, config)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.saveConfigLocked(ctx, config, s)
+}
+
+// saveConfigLocked is saveConfig for the callers which read and modified config under b.lock
+func (b *buddySecretBackend) saveConfigLocked(ctx context.Context, config *buddyConfig, s logical.Storage) error {
+	err := putConfig(ctx, config, s)
 	if err != nil {
 		return err
 	}
-	err = s.Put(ctx, entry)
+	// reset backend because config changed
+	b.resetLocked()
+	b.applyLogLevel(config)
+	return nil
+}
+
+// putConfig writes config to the storage without resetting the backend's client
+func putConfig(ctx context.Context, config *buddyConfig, s logical.Storage) error {
+	config.SchemaVersion = configSchemaVersion
+	if config.usesIdentityToken() {
+		// access tokens exchanged for plugin identity tokens are kept in memory only
+		stored := *config
+		stored.AccessToken = ""
+		stored.AccessTokenExpiresAt = time.Time{}
+		config = &stored
+	}
+	entry, err := secretEntryJSON(configStoragePath, config)
 	if err != nil {
 		return err
 	}
-	// reset backend because config changed
-	b.reset()
-	return nil
+	return s.Put(ctx, entry)
 }
 
 const confHelpSyn = "Configure the Buddy Secret backend"
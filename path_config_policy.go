@@ -0,0 +1,238 @@
+package buddysecrets
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	policyStoragePath = "policy"
+)
+
+// policyEntry holds the guardrails every token role of the mount must satisfy
+type policyEntry struct {
+	DeniedScopes                 []string      `json:"denied_scopes"`
+	MaxTTL                       time.Duration `json:"max_ttl"`
+	RequireWorkspaceRestrictions bool          `json:"require_workspace_restrictions"`
+	RequireIpRestrictions        bool          `json:"require_ip_restrictions"`
+}
+
+func pathConfigPolicy(b *buddySecretBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/policy",
+		Fields: map[string]*framework.FieldSchema{
+			"denied_scopes": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "The list of scopes which no role can have, comma-separated.",
+			},
+			"max_ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "The maximum ttl and max_ttl of the roles. Roles without ttl or max_ttl are checked against the mount defaults. Default: 0 (unlimited)",
+			},
+			"require_workspace_restrictions": {
+				Type:        framework.TypeBool,
+				Description: "Require every role to have workspace_restrictions.",
+			},
+			"require_ip_restrictions": {
+				Type:        framework.TypeBool,
+				Description: "Require every role to have ip_restrictions.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathConfigPolicyRead,
+			},
+			logical.CreateOperation: &framework.PathOperation{
+				Callback:                    b.pathConfigPolicyWrite,
+				ForwardPerformanceSecondary: true,
+				ForwardPerformanceStandby:   true,
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback:                    b.pathConfigPolicyWrite,
+				ForwardPerformanceSecondary: true,
+				ForwardPerformanceStandby:   true,
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback:                    b.pathConfigPolicyDelete,
+				ForwardPerformanceSecondary: true,
+				ForwardPerformanceStandby:   true,
+			},
+		},
+		ExistenceCheck:  b.pathConfigPolicyExistenceCheck,
+		HelpSynopsis:    configPolicyHelpSyn,
+		HelpDescription: configPolicyHelpDesc,
+	}
+}
+
+func getPolicy(ctx context.Context, s logical.Storage) (*policyEntry, error) {
+	entry, err := s.Get(ctx, policyStoragePath)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	policy := new(policyEntry)
+	if err := entry.DecodeJSON(policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// check returns the first guardrail violated by the effective role
+func (p *policyEntry) check(role *roleEntry, sys logical.SystemView) error {
+	if p == nil {
+		return nil
+	}
+	for _, scope := range role.Scopes {
+		for _, denied := range p.DeniedScopes {
+			if scope == denied {
+				return fmt.Errorf("scope '%s' is denied by the mount policy", scope)
+			}
+		}
+	}
+	if p.MaxTTL > 0 {
+		ttl := role.Ttl
+		if ttl == 0 {
+			ttl = sys.DefaultLeaseTTL()
+		}
+		maxTtl := role.MaxTTL
+		if maxTtl == 0 {
+			maxTtl = sys.MaxLeaseTTL()
+		}
+		if ttl > p.MaxTTL || maxTtl > p.MaxTTL {
+			return fmt.Errorf("ttl and max_ttl cannot be greater than %d seconds set in the mount policy", int64(p.MaxTTL.Seconds()))
+		}
+	}
+	if p.RequireWorkspaceRestrictions && len(role.WorkspaceRestrictions) == 0 {
+		return fmt.Errorf("workspace_restrictions are required by the mount policy")
+	}
+	// tokens bound to the client address are always restricted
+	if p.RequireIpRestrictions && len(role.IpRestrictions) == 0 && !role.BindToClientIp {
+		return fmt.Errorf("ip_restrictions are required by the mount policy")
+	}
+	return nil
+}
+
+// checkPolicy checks the effective role against the mount policy
+func (b *buddySecretBackend) checkPolicy(ctx context.Context, s logical.Storage, role *roleEntry) error {
+	policy, err := getPolicy(ctx, s)
+	if err != nil {
+		return err
+	}
+	return policy.check(role, b.System())
+}
+
+func (b *buddySecretBackend) pathConfigPolicyExistenceCheck(ctx context.Context, req *logical.Request, _ *framework.FieldData) (bool, error) {
+	policy, err := getPolicy(ctx, req.Storage)
+	if err != nil {
+		return false, err
+	}
+	return policy != nil, nil
+}
+
+func (b *buddySecretBackend) pathConfigPolicyRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	policy, err := getPolicy(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if policy == nil {
+		return nil, nil
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"denied_scopes":                  policy.DeniedScopes,
+			"max_ttl":                        policy.MaxTTL.Seconds(),
+			"require_workspace_restrictions": policy.RequireWorkspaceRestrictions,
+			"require_ip_restrictions":        policy.RequireIpRestrictions,
+		},
+	}, nil
+}
+
+func (b *buddySecretBackend) pathConfigPolicyWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	policy, err := getPolicy(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if policy == nil {
+		policy = &policyEntry{}
+	}
+	storedScopes := policy.DeniedScopes
+	if deniedScopes, ok := d.GetOk("denied_scopes"); ok {
+		policy.DeniedScopes = normalizeScopes(deniedScopes.([]string))
+	}
+	if err := checkScopes(policy.DeniedScopes, storedScopes, nil); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if maxTtl, ok := d.GetOk("max_ttl"); ok {
+		policy.MaxTTL = time.Duration(maxTtl.(int)) * time.Second
+	}
+	if policy.MaxTTL < 0 {
+		return logical.ErrorResponse("max_ttl cannot be negative"), nil
+	}
+	if requireWorkspace, ok := d.GetOk("require_workspace_restrictions"); ok {
+		policy.RequireWorkspaceRestrictions = requireWorkspace.(bool)
+	}
+	if requireIp, ok := d.GetOk("require_ip_restrictions"); ok {
+		policy.RequireIpRestrictions = requireIp.(bool)
+	}
+	if policy.DeniedScopes == nil {
+		policy.DeniedScopes = []string{}
+	}
+	sort.Strings(policy.DeniedScopes)
+	entry, err := logical.StorageEntryJSON(policyStoragePath, policy)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+	// existing roles are not changed, they stop issuing credentials until fixed and their pools are drained
+	b.refillPoolAsync(req.Storage)
+	names, err := req.Storage.List(ctx, rolesStoragePath+"/")
+	if err != nil {
+		return nil, err
+	}
+	var violations []string
+	for _, name := range names {
+		role, err := getEffectiveRole(ctx, name, req.Storage)
+		if err != nil {
+			violations = append(violations, fmt.Sprintf("%s (%s)", name, err))
+			continue
+		}
+		if role == nil {
+			continue
+		}
+		if err := policy.check(role, b.System()); err != nil {
+			violations = append(violations, fmt.Sprintf("%s (%s)", name, err))
+		}
+	}
+	if len(violations) == 0 {
+		return nil, nil
+	}
+	resp := &logical.Response{}
+	resp.AddWarning(fmt.Sprintf("roles violating the policy will not issue credentials: %s", strings.Join(violations, ", ")))
+	return resp, nil
+}
+
+func (b *buddySecretBackend) pathConfigPolicyDelete(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	if err := req.Storage.Delete(ctx, policyStoragePath); err != nil {
+		return nil, err
+	}
+	b.refillPoolAsync(req.Storage)
+	return nil, nil
+}
+
+const configPolicyHelpSyn = "Configure the guardrails of the token roles."
+const configPolicyHelpDesc = `
+This path configures the policy every token role of the mount must satisfy:
+the denied scopes, the maximum ttl and max_ttl, and the required workspace
+and IP restrictions. Writing the role which violates the policy fails.
+The policy is checked again when credentials are requested, so existing
+roles which violate a new policy stop issuing tokens.
+`
@@ -0,0 +1,283 @@
+package buddysecrets
+
+import (
+	"context"
+	"fmt"
+	"github.com/armon/go-metrics"
+	"github.com/buddy/api-go-sdk/buddy"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/identitytpl"
+	"github.com/hashicorp/vault/sdk/logical"
+	"strconv"
+)
+
+const (
+	SecretTypeElevation = "elevation"
+)
+
+func secretElevation(b *buddySecretBackend) *framework.Secret {
+	return &framework.Secret{
+		Type:   SecretTypeElevation,
+		Renew:  b.elevationRenew,
+		Revoke: b.elevationRevoke,
+	}
+}
+
+// internalInt reads an int saved in the secret internal data, which is float64 after JSON decoding
+func internalInt(data map[string]interface{}, key string) (int, error) {
+	raw, ok := data[key]
+	if !ok {
+		return 0, fmt.Errorf("internal data '%s' not found", key)
+	}
+	switch v := raw.(type) {
+	case int:
+		return v, nil
+	case float64:
+		return int(v), nil
+	}
+	return 0, fmt.Errorf("internal data '%s' is not a number", key)
+}
+
+func (b *buddySecretBackend) elevationRenew(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	roleRaw, ok := req.Secret.InternalData["role"]
+	if !ok {
+		return nil, fmt.Errorf("internal data 'role' not found")
+	}
+	role, err := getElevationRole(ctx, roleRaw.(string), req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, nil
+	}
+	resp := &logical.Response{Secret: req.Secret}
+	resp.Secret.TTL = role.Ttl
+	resp.Secret.MaxTTL = role.MaxTTL
+	return resp, nil
+}
+
+func (b *buddySecretBackend) elevationRevoke(ctx context.Context, req *logical.Request, _ *framework.FieldData) (_ *logical.Response, err error) {
+	defer func() {
+		roleName, _ := req.Secret.InternalData["role"].(string)
+		incrCounter([]string{"elevation", "revoked"},
+			metrics.Label{Name: "role", Value: roleName},
+			metrics.Label{Name: "status", Value: metricsStatus(err)})
+	}()
+	workspaceRaw, ok := req.Secret.InternalData["workspace"]
+	if !ok {
+		return nil, fmt.Errorf("internal data 'workspace' not found")
+	}
+	workspace := workspaceRaw.(string)
+	memberId, err := internalInt(req.Secret.InternalData, "member_id")
+	if err != nil {
+		return nil, err
+	}
+	client, err := b.getClient(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if project, ok := req.Secret.InternalData["project"].(string); ok && project != "" {
+		previousPermissionId, err := internalInt(req.Secret.InternalData, "previous_permission_id")
+		if err != nil {
+			return nil, err
+		}
+		if previousPermissionId != 0 {
+			err = client.UpdateProjectMember(workspace, project, memberId, previousPermissionId)
+		} else {
+			err = client.DeleteProjectMember(workspace, project, memberId)
+		}
+		return nil, ignoreNotFound(err)
+	}
+	groupId, err := internalInt(req.Secret.InternalData, "group_id")
+	if err != nil {
+		return nil, err
+	}
+	err = client.DeleteGroupMember(workspace, groupId, memberId)
+	return nil, ignoreNotFound(err)
+}
+
+// ignoreNotFound treats the member already removed in buddy as revoked, so the lease can expire
+func ignoreNotFound(err error) error {
+	if isNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// elevationMember returns the email or the ID of the member to elevate
+func (b *buddySecretBackend) elevationMember(req *logical.Request, role *elevationRoleEntry, d *framework.FieldData) (string, error) {
+	email := d.Get("email").(string)
+	memberId := d.Get("member_id").(int)
+	if role.MemberTemplate == "" {
+		if email != "" && memberId != 0 {
+			return "", fmt.Errorf("email and member_id cannot be set together")
+		}
+		if memberId != 0 {
+			return strconv.Itoa(memberId), nil
+		}
+		if email == "" {
+			return "", fmt.Errorf("email or member_id must be provided")
+		}
+		return email, nil
+	}
+	if email != "" || memberId != 0 {
+		return "", fmt.Errorf("member is resolved from the role template and cannot be provided")
+	}
+	if req.EntityID == "" {
+		return "", fmt.Errorf("no entity associated with the request")
+	}
+	entity, err := b.System().EntityInfo(req.EntityID)
+	if err != nil {
+		return "", err
+	}
+	if entity == nil {
+		return "", fmt.Errorf("entity '%s' not found", req.EntityID)
+	}
+	groups, err := b.System().GroupsForEntity(req.EntityID)
+	if err != nil {
+		return "", err
+	}
+	_, member, err := identitytpl.PopulateString(identitytpl.PopulateStringInput{
+		Mode:   identitytpl.ACLTemplating,
+		String: role.MemberTemplate,
+		Entity: entity,
+		Groups: groups,
+	})
+	if err != nil {
+		return "", err
+	}
+	return member, nil
+}
+
+func (b *buddySecretBackend) pathElevationWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil || !config.hasRootCredential() {
+		return logical.ErrorResponse("root token not provided through config"), nil
+	}
+	client, err := b.getClient(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	roleName := d.Get("role").(string)
+	role, err := getElevationRole(ctx, roleName, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("elevation role '%s' does not exist", roleName)), nil
+	}
+	memberRef, err := b.elevationMember(req, role, d)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	var member *buddy.Member
+	if id, err := strconv.Atoi(memberRef); err == nil {
+		member, err = client.GetMember(role.Workspace, id)
+		if isNotFound(err) {
+			member, err = nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		member, err = client.FindMemberByEmail(role.Workspace, memberRef)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if member == nil {
+		return logical.ErrorResponse(fmt.Sprintf("member '%s' not found in workspace '%s'", memberRef, role.Workspace)), nil
+	}
+	data := map[string]interface{}{
+		"workspace": role.Workspace,
+		"member_id": member.Id,
+		"email":     member.Email,
+	}
+	internalData := map[string]interface{}{
+		"role":      roleName,
+		"workspace": role.Workspace,
+		"member_id": member.Id,
+	}
+	if role.Project != "" {
+		projectMember, err := client.GetProjectMember(role.Workspace, role.Project, member.Id)
+		if err != nil {
+			return nil, err
+		}
+		previousPermissionId := 0
+		if projectMember != nil {
+			if projectMember.PermissionSet != nil {
+				previousPermissionId = projectMember.PermissionSet.Id
+			}
+			if previousPermissionId == role.PermissionId {
+				return logical.ErrorResponse("member already has the permission in the project"), nil
+			}
+			err = client.UpdateProjectMember(role.Workspace, role.Project, member.Id, role.PermissionId)
+		} else {
+			err = client.AddProjectMember(role.Workspace, role.Project, member.Id, role.PermissionId)
+		}
+		if err != nil {
+			return nil, err
+		}
+		data["project"] = role.Project
+		data["permission_id"] = role.PermissionId
+		internalData["project"] = role.Project
+		internalData["previous_permission_id"] = previousPermissionId
+	} else {
+		groupMember, err := client.GetGroupMember(role.Workspace, role.GroupId, member.Id)
+		if err != nil {
+			return nil, err
+		}
+		// removing standing membership on revoke must be avoided
+		if groupMember != nil {
+			return logical.ErrorResponse("member already belongs to the group"), nil
+		}
+		err = client.AddGroupMember(role.Workspace, role.GroupId, member.Id)
+		if err != nil {
+			return nil, err
+		}
+		data["group_id"] = role.GroupId
+		internalData["group_id"] = role.GroupId
+	}
+	resp := b.Secret(SecretTypeElevation).Response(data, internalData)
+	resp.Secret.TTL = role.Ttl
+	resp.Secret.MaxTTL = role.MaxTTL
+	return resp, nil
+}
+
+func pathElevation(b *buddySecretBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: fmt.Sprintf("elevations/%s", framework.GenericNameRegex("role")),
+		Fields: map[string]*framework.FieldSchema{
+			"role": {
+				Type:        framework.TypeLowerCaseString,
+				Description: "The name of the Vault elevation role",
+			},
+			"email": {
+				Type:        framework.TypeString,
+				Description: "The email of the workspace member to elevate. Not allowed if the role has `member_template`.",
+			},
+			"member_id": {
+				Type:        framework.TypeInt,
+				Description: "The ID of the workspace member to elevate. Not allowed if the role has `member_template`.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback:                    b.pathElevationWrite,
+				ForwardPerformanceSecondary: true,
+				ForwardPerformanceStandby:   true,
+			},
+		},
+		HelpSynopsis:    elevationHelpSyn,
+		HelpDescription: elevationHelpDesc,
+	}
+}
+
+const elevationHelpSyn = "Temporarily elevate a Buddy workspace member using the given Vault elevation role."
+const elevationHelpDesc = `
+This path adds the member to the group or project permission of the role.
+The elevation will be automatically reverted when the lease time has expired.
+`
@@ -0,0 +1,234 @@
+package buddysecrets
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/identitytpl"
+	"github.com/hashicorp/vault/sdk/logical"
+	"time"
+)
+
+const (
+	elevationRolesStoragePath = "elevation-roles"
+)
+
+type elevationRoleEntry struct {
+	Ttl            time.Duration `json:"ttl"`
+	MaxTTL         time.Duration `json:"max_ttl"`
+	Workspace      string        `json:"workspace"`
+	GroupId        int           `json:"group_id"`
+	Project        string        `json:"project"`
+	PermissionId   int           `json:"permission_id"`
+	MemberTemplate string        `json:"member_template"`
+}
+
+func pathElevationRole(b *buddySecretBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "elevation-roles/" + framework.GenericNameRegex("name"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeLowerCaseString,
+				Description: "The name of the elevation role",
+			},
+			"ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "The default lease time of the elevation after which the member is automatically removed. If not set or set to 0, system default is used.",
+			},
+			"max_ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "The maximum time the elevation can be extended to before it eventually expires. If not set or set to 0, system default is used.",
+			},
+			"workspace": {
+				Type:        framework.TypeString,
+				Description: "The domain of the workspace. Required",
+			},
+			"group_id": {
+				Type:        framework.TypeInt,
+				Description: "The ID of the group to which the member is added.",
+			},
+			"project": {
+				Type:        framework.TypeString,
+				Description: "The name of the project to which the member is added with `permission_id`.",
+			},
+			"permission_id": {
+				Type:        framework.TypeInt,
+				Description: "The ID of the permission set granted to the member in `project`.",
+			},
+			"member_template": {
+				Type:        framework.TypeString,
+				Description: "The identity template resolving to the email or ID of the member, e.g. `{{identity.entity.metadata.email}}`. If set, the member cannot be passed in the elevation request.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathElevationRoleRead,
+			},
+			logical.CreateOperation: &framework.PathOperation{
+				Callback:                    b.pathElevationRoleWrite,
+				ForwardPerformanceSecondary: true,
+				ForwardPerformanceStandby:   true,
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback:                    b.pathElevationRoleWrite,
+				ForwardPerformanceSecondary: true,
+				ForwardPerformanceStandby:   true,
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback:                    b.pathElevationRoleDelete,
+				ForwardPerformanceSecondary: true,
+				ForwardPerformanceStandby:   true,
+			},
+		},
+		ExistenceCheck:  b.pathElevationRoleExistenceCheck,
+		HelpSynopsis:    elevationRoleHelpSyn,
+		HelpDescription: elevationRoleHelpDesc,
+	}
+}
+
+func saveElevationRole(ctx context.Context, s logical.Storage, c *elevationRoleEntry, name string) error {
+	entry, err := logical.StorageEntryJSON(fmt.Sprintf("%s/%s", elevationRolesStoragePath, name), c)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+func getElevationRole(ctx context.Context, name string, s logical.Storage) (*elevationRoleEntry, error) {
+	entry, err := s.Get(ctx, fmt.Sprintf("%s/%s", elevationRolesStoragePath, name))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	role := new(elevationRoleEntry)
+	if err := entry.DecodeJSON(role); err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+func (r *elevationRoleEntry) validate() error {
+	if r.MaxTTL != 0 && r.Ttl > r.MaxTTL {
+		return fmt.Errorf("ttl cannot be greater than max_ttl")
+	}
+	if r.Workspace == "" {
+		return fmt.Errorf("workspace must be provided")
+	}
+	if r.GroupId != 0 && r.Project != "" {
+		return fmt.Errorf("group_id and project cannot be set together")
+	}
+	if r.GroupId == 0 && r.Project == "" {
+		return fmt.Errorf("group_id or project must be provided")
+	}
+	if r.Project != "" && r.PermissionId == 0 {
+		return fmt.Errorf("permission_id must be provided with project")
+	}
+	if r.MemberTemplate != "" {
+		_, _, err := identitytpl.PopulateString(identitytpl.PopulateStringInput{
+			Mode:              identitytpl.ACLTemplating,
+			String:            r.MemberTemplate,
+			ValidityCheckOnly: true,
+		})
+		if err != nil {
+			return fmt.Errorf("invalid member_template: %s", err)
+		}
+	}
+	return nil
+}
+
+func (b *buddySecretBackend) pathElevationRoleExistenceCheck(ctx context.Context, req *logical.Request, d *framework.FieldData) (bool, error) {
+	name := d.Get("name").(string)
+	role, err := getElevationRole(ctx, name, req.Storage)
+	if err != nil {
+		return false, err
+	}
+	return role != nil, nil
+}
+
+func (b *buddySecretBackend) pathElevationRoleDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	err := req.Storage.Delete(ctx, fmt.Sprintf("%s/%s", elevationRolesStoragePath, name))
+	return nil, err
+}
+
+func (b *buddySecretBackend) pathElevationRoleRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	role, err := getElevationRole(ctx, name, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, nil
+	}
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"ttl":             role.Ttl.Seconds(),
+			"max_ttl":         role.MaxTTL.Seconds(),
+			"workspace":       role.Workspace,
+			"group_id":        role.GroupId,
+			"project":         role.Project,
+			"permission_id":   role.PermissionId,
+			"member_template": role.MemberTemplate,
+		},
+	}
+	return resp, nil
+}
+
+func (b *buddySecretBackend) pathElevationRoleWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	role, err := getElevationRole(ctx, name, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		if req.Operation == logical.UpdateOperation {
+			return logical.ErrorResponse("elevation role not found during update operation"), nil
+		}
+		role = &elevationRoleEntry{}
+	}
+	if ttl, ok := d.GetOk("ttl"); ok {
+		role.Ttl = time.Duration(ttl.(int)) * time.Second
+	} else if req.Operation == logical.CreateOperation {
+		role.Ttl = time.Duration(d.Get("ttl").(int)) * time.Second
+	}
+	if maxTtl, ok := d.GetOk("max_ttl"); ok {
+		role.MaxTTL = time.Duration(maxTtl.(int)) * time.Second
+	} else if req.Operation == logical.CreateOperation {
+		role.MaxTTL = time.Duration(d.Get("max_ttl").(int)) * time.Second
+	}
+	if workspace, ok := d.GetOk("workspace"); ok {
+		role.Workspace = workspace.(string)
+	}
+	if groupId, ok := d.GetOk("group_id"); ok {
+		role.GroupId = groupId.(int)
+	}
+	if project, ok := d.GetOk("project"); ok {
+		role.Project = project.(string)
+	}
+	if permissionId, ok := d.GetOk("permission_id"); ok {
+		role.PermissionId = permissionId.(int)
+	}
+	if memberTemplate, ok := d.GetOk("member_template"); ok {
+		role.MemberTemplate = memberTemplate.(string)
+	}
+	if err := role.validate(); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	err = saveElevationRole(ctx, req.Storage, role, name)
+	return nil, err
+}
+
+const elevationRoleHelpSyn = "Manage the Vault roles used to temporarily elevate Buddy members."
+
+const elevationRoleHelpDesc = `
+This path allows you to read and write roles that are used to temporarily
+add workspace members to a privileged group or project permission. If the
+backend is mounted at "buddy", you would create a Vault role at
+"buddy/elevation-roles/my_role" and request the elevation at
+"buddy/elevations/my_role".
+
+The member is removed from the group (or the previous project permission
+is restored) when the lease expires.
+`
@@ -0,0 +1,31 @@
+package buddysecrets
+
+import (
+	"context"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathElevationRoles(b *buddySecretBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "elevation-roles/?",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ListOperation: &framework.PathOperation{
+				Callback: b.pathElevationRolesList,
+			},
+		},
+		HelpSynopsis:    elevationRolesHelpSyn,
+		HelpDescription: elevationRolesHelpDesc,
+	}
+}
+
+func (b *buddySecretBackend) pathElevationRolesList(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	roles, err := req.Storage.List(ctx, elevationRolesStoragePath+"/")
+	if err != nil {
+		return nil, err
+	}
+	return logical.ListResponse(roles), nil
+}
+
+const elevationRolesHelpSyn = "List existing elevation roles."
+const elevationRolesHelpDesc = "List existing elevation roles by name."
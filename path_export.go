@@ -0,0 +1,773 @@
+package buddysecrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/yaml.v3"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	exportDocumentVersion = 1
+	exportFormatJson      = "json"
+	exportFormatYaml      = "yaml"
+	importModeMerge       = "merge"
+	importModeReplace     = "replace"
+)
+
+// exportDocument holds every role of the engine. Integration credentials are never exported,
+// the imported integration roles keep the credentials of the existing ones
+type exportDocument struct {
+	Version          int                              `json:"version"`
+	Config           map[string]interface{}           `json:"config,omitempty"`
+	Roles            map[string]*roleEntry            `json:"roles"`
+	RoleTemplates    map[string]*roleTemplateEntry    `json:"role_templates"`
+	IntegrationRoles map[string]*integrationRoleEntry `json:"integration_roles"`
+	ElevationRoles   map[string]*elevationRoleEntry   `json:"elevation_roles"`
+	ScopeSets        map[string]*scopeSetEntry        `json:"scope_sets"`
+}
+
+// importDiff lists the changed entries per kind
+type importDiff map[string]map[string][]string
+
+func pathExport(b *buddySecretBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "export",
+			Fields: map[string]*framework.FieldSchema{
+				"format": {
+					Type:          framework.TypeString,
+					Description:   "The format of the document, `json` or `yaml`. Default: `json`",
+					Default:       exportFormatJson,
+					AllowedValues: []interface{}{exportFormatJson, exportFormatYaml},
+					Query:         true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathExportRead,
+				},
+			},
+			HelpSynopsis:    exportHelpSyn,
+			HelpDescription: exportHelpDesc,
+		},
+		{
+			Pattern: "import",
+			Fields: map[string]*framework.FieldSchema{
+				"document": {
+					Type:        framework.TypeString,
+					Description: "The document produced by the export endpoint, in JSON or YAML.",
+					Required:    true,
+				},
+				"mode": {
+					Type:          framework.TypeString,
+					Description:   "`merge` adds and updates the roles from the document, `replace` also deletes the roles missing in it. Default: `merge`",
+					Default:       importModeMerge,
+					AllowedValues: []interface{}{importModeMerge, importModeReplace},
+				},
+				"dry_run": {
+					Type:        framework.TypeBool,
+					Description: "Validate the document and return the changes without applying them.",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback:                    b.pathImportWrite,
+					ForwardPerformanceSecondary: true,
+					ForwardPerformanceStandby:   true,
+				},
+			},
+			HelpSynopsis:    importHelpSyn,
+			HelpDescription: importHelpDesc,
+		},
+	}
+}
+
+// loadDocument reads every role of the engine from storage
+func loadDocument(ctx context.Context, s logical.Storage) (*exportDocument, error) {
+	doc := &exportDocument{
+		Version:          exportDocumentVersion,
+		Roles:            map[string]*roleEntry{},
+		RoleTemplates:    map[string]*roleTemplateEntry{},
+		IntegrationRoles: map[string]*integrationRoleEntry{},
+		ElevationRoles:   map[string]*elevationRoleEntry{},
+		ScopeSets:        map[string]*scopeSetEntry{},
+	}
+	names, err := s.List(ctx, rolesStoragePath+"/")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		role, err := getRole(ctx, name, s)
+		if err != nil {
+			return nil, err
+		}
+		if role != nil {
+			doc.Roles[name] = role
+		}
+	}
+	names, err = s.List(ctx, roleTemplatesStoragePath+"/")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		template, err := getRoleTemplate(ctx, name, s)
+		if err != nil {
+			return nil, err
+		}
+		if template != nil {
+			doc.RoleTemplates[name] = template
+		}
+	}
+	names, err = s.List(ctx, integrationRolesStoragePath+"/")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		role, err := getIntegrationRole(ctx, name, s)
+		if err != nil {
+			return nil, err
+		}
+		if role != nil {
+			doc.IntegrationRoles[name] = role
+		}
+	}
+	names, err = s.List(ctx, elevationRolesStoragePath+"/")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		role, err := getElevationRole(ctx, name, s)
+		if err != nil {
+			return nil, err
+		}
+		if role != nil {
+			doc.ElevationRoles[name] = role
+		}
+	}
+	names, err = s.List(ctx, scopeSetsStoragePath+"/")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		set, err := getScopeSet(ctx, name, s)
+		if err != nil {
+			return nil, err
+		}
+		if set != nil {
+			doc.ScopeSets[name] = set
+		}
+	}
+	return doc, nil
+}
+
+// marshalDocument encodes the document in the given format
+func marshalDocument(doc *exportDocument, format string) (string, error) {
+	raw, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if format == exportFormatJson {
+		return string(raw), nil
+	}
+	var generic interface{}
+	if err := json.Unmarshal(raw, &generic); err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(integralNumbers(generic)); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// integralNumbers converts the whole float64 numbers decoded from JSON to int64,
+// so durations are not encoded in YAML in the exponent notation
+func integralNumbers(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for key, value := range t {
+			t[key] = integralNumbers(value)
+		}
+	case []interface{}:
+		for i, value := range t {
+			t[i] = integralNumbers(value)
+		}
+	case float64:
+		if t == math.Trunc(t) && math.Abs(t) < math.MaxInt64 {
+			return int64(t)
+		}
+	}
+	return v
+}
+
+// unmarshalDocument decodes the document in JSON or YAML (JSON is valid YAML)
+func unmarshalDocument(raw string) (*exportDocument, error) {
+	var generic interface{}
+	if err := yaml.Unmarshal([]byte(raw), &generic); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(generic)
+	if err != nil {
+		return nil, err
+	}
+	doc := new(exportDocument)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(doc); err != nil {
+		return nil, err
+	}
+	if doc.Version != exportDocumentVersion {
+		return nil, fmt.Errorf("unsupported document version %d", doc.Version)
+	}
+	return doc, nil
+}
+
+// sameJSON compares the entries by their stored representation
+func sameJSON(a interface{}, b interface{}) bool {
+	rawA, errA := json.Marshal(a)
+	rawB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(rawA, rawB)
+}
+
+// diffEntries returns the names added to, updated in and removed from the current entries
+func diffEntries[T any](current map[string]T, final map[string]T) map[string][]string {
+	diff := map[string][]string{
+		"added":   {},
+		"updated": {},
+		"removed": {},
+	}
+	for name, entry := range final {
+		if cur, ok := current[name]; !ok {
+			diff["added"] = append(diff["added"], name)
+		} else if !sameJSON(cur, entry) {
+			diff["updated"] = append(diff["updated"], name)
+		}
+	}
+	for name := range current {
+		if _, ok := final[name]; !ok {
+			diff["removed"] = append(diff["removed"], name)
+		}
+	}
+	for _, names := range diff {
+		sort.Strings(names)
+	}
+	return diff
+}
+
+// buildImport validates the document and returns the final state of the roles. The integration roles
+// which cannot get any credentials are skipped and returned with the skipped names
+func buildImport(current *exportDocument, doc *exportDocument, mode string, policy *policyEntry, config *buddyConfig, sys logical.SystemView) (*exportDocument, []string, error) {
+	final := &exportDocument{
+		Version:          exportDocumentVersion,
+		Roles:            map[string]*roleEntry{},
+		RoleTemplates:    map[string]*roleTemplateEntry{},
+		IntegrationRoles: map[string]*integrationRoleEntry{},
+		ElevationRoles:   map[string]*elevationRoleEntry{},
+		ScopeSets:        map[string]*scopeSetEntry{},
+	}
+	var skipped []string
+	if mode == importModeMerge {
+		for name, entry := range current.Roles {
+			final.Roles[name] = entry
+		}
+		for name, entry := range current.RoleTemplates {
+			final.RoleTemplates[name] = entry
+		}
+		for name, entry := range current.IntegrationRoles {
+			final.IntegrationRoles[name] = entry
+		}
+		for name, entry := range current.ElevationRoles {
+			final.ElevationRoles[name] = entry
+		}
+		for name, entry := range current.ScopeSets {
+			final.ScopeSets[name] = entry
+		}
+	}
+	for name, set := range doc.ScopeSets {
+		if err := validateImportName(name); err != nil {
+			return nil, nil, err
+		}
+		if _, ok := builtinScopeSets[name]; ok {
+			return nil, nil, fmt.Errorf("built-in scope set '%s' cannot be changed", name)
+		}
+		if set == nil || len(set.Scopes) == 0 {
+			return nil, nil, fmt.Errorf("scope set '%s': scopes must be provided", name)
+		}
+		set.Scopes = normalizeScopes(set.Scopes)
+		sort.Strings(set.Scopes)
+		var stored []string
+		if current.ScopeSets[name] != nil {
+			stored = current.ScopeSets[name].Scopes
+		}
+		if err := checkScopes(set.Scopes, stored, nil); err != nil {
+			return nil, nil, fmt.Errorf("scope set '%s': %s", name, err)
+		}
+		final.ScopeSets[name] = set
+	}
+	setExists := func(name string) (bool, error) {
+		_, builtin := builtinScopeSets[name]
+		return builtin || final.ScopeSets[name] != nil, nil
+	}
+	for name, template := range doc.RoleTemplates {
+		if err := validateImportName(name); err != nil {
+			return nil, nil, err
+		}
+		if template == nil {
+			template = &roleTemplateEntry{}
+		}
+		if template.Scopes == nil {
+			template.Scopes = []string{}
+		}
+		template.Scopes = normalizeScopes(template.Scopes)
+		if template.IpRestrictions == nil {
+			template.IpRestrictions = []string{}
+		}
+		if template.WorkspaceRestrictions == nil {
+			template.WorkspaceRestrictions = []string{}
+		}
+		sort.Strings(template.Scopes)
+		sort.Strings(template.IpRestrictions)
+		sort.Strings(template.WorkspaceRestrictions)
+		if err := template.validate(); err != nil {
+			return nil, nil, fmt.Errorf("role template '%s': %s", name, err)
+		}
+		var stored []string
+		if current.RoleTemplates[name] != nil {
+			stored = current.RoleTemplates[name].Scopes
+		}
+		if err := checkScopes(template.Scopes, stored, setExists); err != nil {
+			return nil, nil, fmt.Errorf("role template '%s': %s", name, err)
+		}
+		final.RoleTemplates[name] = template
+	}
+	for name := range final.RoleTemplates {
+		seen := map[string]bool{name: true}
+		for parent := final.RoleTemplates[name].Parent; parent != ""; parent = final.RoleTemplates[parent].Parent {
+			if seen[parent] {
+				return nil, nil, fmt.Errorf("role template '%s' would inherit from itself", name)
+			}
+			seen[parent] = true
+			if final.RoleTemplates[parent] == nil {
+				return nil, nil, fmt.Errorf("role template '%s' does not exist", parent)
+			}
+		}
+	}
+	for name, role := range doc.Roles {
+		if err := validateImportName(name); err != nil {
+			return nil, nil, err
+		}
+		if role == nil {
+			role = &roleEntry{}
+		}
+		if role.MaxVersions == 0 {
+			role.MaxVersions = roleDefaultMaxVersions
+		}
+		if role.Delivery == "" {
+			role.Delivery = roleDeliveryDirect
+		}
+		// documents are exported in the current schema, older ones differ only by the defaults above
+		role.SchemaVersion = roleSchemaVersion
+		if role.Scopes == nil {
+			role.Scopes = []string{}
+		}
+		role.Scopes = normalizeScopes(role.Scopes)
+		if role.IpRestrictions == nil {
+			role.IpRestrictions = []string{}
+		}
+		if role.WorkspaceRestrictions == nil {
+			role.WorkspaceRestrictions = []string{}
+		}
+		if role.Tags == nil {
+			role.Tags = map[string]string{}
+		}
+		sort.Strings(role.Scopes)
+		sort.Strings(role.IpRestrictions)
+		sort.Strings(role.WorkspaceRestrictions)
+		if err := role.validate(); err != nil {
+			return nil, nil, fmt.Errorf("role '%s': %s", name, err)
+		}
+		var stored []string
+		if current.Roles[name] != nil {
+			stored = current.Roles[name].Scopes
+		}
+		if err := checkScopes(role.Scopes, stored, setExists); err != nil {
+			return nil, nil, fmt.Errorf("role '%s': %s", name, err)
+		}
+		if role.Template != "" && final.RoleTemplates[role.Template] == nil {
+			return nil, nil, fmt.Errorf("role '%s': role template '%s' does not exist", name, role.Template)
+		}
+		// the history metadata is kept from the existing role, so unchanged roles are not updated
+		role.Version, role.CreatedAt, role.UpdatedAt, role.UpdatedBy = 0, time.Time{}, time.Time{}, ""
+		if cur, ok := current.Roles[name]; ok {
+			role.Version, role.CreatedAt, role.UpdatedAt, role.UpdatedBy = cur.Version, cur.CreatedAt, cur.UpdatedAt, cur.UpdatedBy
+		}
+		final.Roles[name] = role
+	}
+	for name, role := range final.Roles {
+		if role.Template != "" && final.RoleTemplates[role.Template] == nil {
+			return nil, nil, fmt.Errorf("role '%s': role template '%s' does not exist", name, role.Template)
+		}
+	}
+	resolver := &roleResolver{
+		template: func(name string) (*roleTemplateEntry, error) {
+			return final.RoleTemplates[name], nil
+		},
+		scopeSet: func(name string) (*scopeSetEntry, error) {
+			if set, ok := builtinScopeSets[name]; ok {
+				return set, nil
+			}
+			return final.ScopeSets[name], nil
+		},
+	}
+	for name := range doc.Roles {
+		effective, err := resolver.resolve(final.Roles[name])
+		if err != nil {
+			return nil, nil, fmt.Errorf("role '%s': %s", name, err)
+		}
+		if err := policy.check(effective, sys); err != nil {
+			return nil, nil, fmt.Errorf("role '%s': %s", name, err)
+		}
+	}
+	for name, role := range doc.IntegrationRoles {
+		if err := validateImportName(name); err != nil {
+			return nil, nil, err
+		}
+		if role == nil {
+			role = &integrationRoleEntry{}
+		}
+		if len(role.Credentials) == 0 {
+			if cur, ok := current.IntegrationRoles[name]; ok {
+				role.Credentials = cur.Credentials
+			}
+		}
+		// exported inline credentials are stripped, the role cannot be created without them
+		if len(role.Credentials) == 0 && role.CredentialsPath == "" {
+			skipped = append(skipped, name)
+			continue
+		}
+		if role.Projects == nil {
+			role.Projects = []string{}
+		}
+		if role.Credentials == nil {
+			role.Credentials = map[string]string{}
+		}
+		sort.Strings(role.Projects)
+		if err := role.validate(); err != nil {
+			return nil, nil, fmt.Errorf("integration role '%s': %s", name, err)
+		}
+		if role.CredentialsPath != "" {
+			if err := checkCredentialsPath(config, role.CredentialsPath); err != nil {
+				return nil, nil, fmt.Errorf("integration role '%s': %s", name, err)
+			}
+		}
+		final.IntegrationRoles[name] = role
+	}
+	for name, role := range doc.ElevationRoles {
+		if err := validateImportName(name); err != nil {
+			return nil, nil, err
+		}
+		if role == nil {
+			role = &elevationRoleEntry{}
+		}
+		if err := role.validate(); err != nil {
+			return nil, nil, fmt.Errorf("elevation role '%s': %s", name, err)
+		}
+		final.ElevationRoles[name] = role
+	}
+	return final, skipped, nil
+}
+
+// validateImportName checks the name against the pattern of the role paths
+func validateImportName(name string) error {
+	if name == "" || strings.ToLower(name) != name || strings.ContainsAny(name, "/ ") {
+		return fmt.Errorf("invalid name '%s'", name)
+	}
+	return nil
+}
+
+// applyImport writes the changed entries of the final document and deletes the removed ones
+func (b *buddySecretBackend) applyImport(ctx context.Context, req *logical.Request, current *exportDocument, final *exportDocument, diff importDiff) error {
+	// scope sets and templates first, so the roles never point to a missing one
+	for _, name := range append(diff["scope_sets"]["added"], diff["scope_sets"]["updated"]...) {
+		if err := saveScopeSet(ctx, req.Storage, final.ScopeSets[name], name); err != nil {
+			return err
+		}
+	}
+	for _, name := range append(diff["role_templates"]["added"], diff["role_templates"]["updated"]...) {
+		if err := saveRoleTemplate(ctx, req.Storage, final.RoleTemplates[name], name); err != nil {
+			return err
+		}
+	}
+	now := time.Now()
+	var refill []string
+	for _, name := range append(diff["roles"]["added"], diff["roles"]["updated"]...) {
+		role := final.Roles[name]
+		if role.CreatedAt.IsZero() {
+			role.CreatedAt = now
+		}
+		role.UpdatedAt = now
+		role.UpdatedBy = requestAuthor(req)
+		if err := saveRoleVersion(ctx, req.Storage, role, name); err != nil {
+			return err
+		}
+		if cur, ok := current.Roles[name]; role.PoolSize > 0 || (ok && cur.PoolSize > 0) {
+			refill = append(refill, name)
+		}
+	}
+	for _, name := range diff["roles"]["removed"] {
+		if err := req.Storage.Delete(ctx, fmt.Sprintf("%s/%s", rolesStoragePath, name)); err != nil {
+			return err
+		}
+		if err := deleteRoleVersions(ctx, req.Storage, name); err != nil {
+			return err
+		}
+		refill = append(refill, name)
+	}
+	for _, name := range diff["role_templates"]["removed"] {
+		if err := req.Storage.Delete(ctx, fmt.Sprintf("%s/%s", roleTemplatesStoragePath, name)); err != nil {
+			return err
+		}
+	}
+	for _, name := range append(diff["integration_roles"]["added"], diff["integration_roles"]["updated"]...) {
+		if err := saveIntegrationRole(ctx, req.Storage, final.IntegrationRoles[name], name); err != nil {
+			return err
+		}
+	}
+	for _, name := range diff["integration_roles"]["removed"] {
+		if err := req.Storage.Delete(ctx, fmt.Sprintf("%s/%s", integrationRolesStoragePath, name)); err != nil {
+			return err
+		}
+	}
+	for _, name := range append(diff["elevation_roles"]["added"], diff["elevation_roles"]["updated"]...) {
+		if err := saveElevationRole(ctx, req.Storage, final.ElevationRoles[name], name); err != nil {
+			return err
+		}
+	}
+	for _, name := range diff["elevation_roles"]["removed"] {
+		if err := req.Storage.Delete(ctx, fmt.Sprintf("%s/%s", elevationRolesStoragePath, name)); err != nil {
+			return err
+		}
+	}
+	for _, name := range diff["scope_sets"]["removed"] {
+		if err := req.Storage.Delete(ctx, fmt.Sprintf("%s/%s", scopeSetsStoragePath, name)); err != nil {
+			return err
+		}
+	}
+	if len(refill) > 0 {
+		b.refillPoolAsync(req.Storage, refill...)
+	}
+	return nil
+}
+
+// restoreImport writes back the entries and the role versions saved before the failed import
+func (b *buddySecretBackend) restoreImport(ctx context.Context, s logical.Storage, current *exportDocument, diff importDiff, versions map[string][]*logical.StorageEntry) error {
+	for name, role := range current.Roles {
+		if err := saveRole(ctx, s, role, name); err != nil {
+			return err
+		}
+	}
+	if err := restoreRoleVersions(ctx, s, versions); err != nil {
+		return err
+	}
+	for _, name := range diff["roles"]["added"] {
+		if err := s.Delete(ctx, fmt.Sprintf("%s/%s", rolesStoragePath, name)); err != nil {
+			return err
+		}
+		if err := deleteRoleVersions(ctx, s, name); err != nil {
+			return err
+		}
+	}
+	for name, template := range current.RoleTemplates {
+		if err := saveRoleTemplate(ctx, s, template, name); err != nil {
+			return err
+		}
+	}
+	for _, name := range diff["role_templates"]["added"] {
+		if err := s.Delete(ctx, fmt.Sprintf("%s/%s", roleTemplatesStoragePath, name)); err != nil {
+			return err
+		}
+	}
+	for name, role := range current.IntegrationRoles {
+		if err := saveIntegrationRole(ctx, s, role, name); err != nil {
+			return err
+		}
+	}
+	for _, name := range diff["integration_roles"]["added"] {
+		if err := s.Delete(ctx, fmt.Sprintf("%s/%s", integrationRolesStoragePath, name)); err != nil {
+			return err
+		}
+	}
+	for name, role := range current.ElevationRoles {
+		if err := saveElevationRole(ctx, s, role, name); err != nil {
+			return err
+		}
+	}
+	for _, name := range diff["elevation_roles"]["added"] {
+		if err := s.Delete(ctx, fmt.Sprintf("%s/%s", elevationRolesStoragePath, name)); err != nil {
+			return err
+		}
+	}
+	for name, set := range current.ScopeSets {
+		if err := saveScopeSet(ctx, s, set, name); err != nil {
+			return err
+		}
+	}
+	for _, name := range diff["scope_sets"]["added"] {
+		if err := s.Delete(ctx, fmt.Sprintf("%s/%s", scopeSetsStoragePath, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *buddySecretBackend) pathExportRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	format := d.Get("format").(string)
+	if format != exportFormatJson && format != exportFormatYaml {
+		return logical.ErrorResponse("format must be json or yaml"), nil
+	}
+	doc, err := loadDocument(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	for name, role := range doc.IntegrationRoles {
+		exported := *role
+		exported.Credentials = nil
+		doc.IntegrationRoles[name] = &exported
+	}
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config != nil {
+		config.zeroSecrets()
+		doc.Config = map[string]interface{}{
+			"base_url":          config.BaseUrl,
+			"insecure":          config.Insecure,
+			"token_ttl_in_days": config.TokenTtlInDays,
+			"token_auto_rotate": config.TokenAutoRotate,
+			"vault_addr":        config.VaultAddr,
+			"client_id":         config.ClientId,
+		}
+		if len(config.WorkspaceTokens) > 0 {
+			// the workspace root tokens are not exported, only the workspaces which need them
+			doc.Config["workspaces"] = config.workspaceDomains()
+		}
+		config.PopulatePluginIdentityTokenData(doc.Config)
+	}
+	document, err := marshalDocument(doc, format)
+	if err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"version":  exportDocumentVersion,
+			"format":   format,
+			"document": document,
+		},
+	}, nil
+}
+
+func (b *buddySecretBackend) pathImportWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	mode := d.Get("mode").(string)
+	if mode != importModeMerge && mode != importModeReplace {
+		return logical.ErrorResponse("mode must be merge or replace"), nil
+	}
+	doc, err := unmarshalDocument(d.Get("document").(string))
+	if err != nil {
+		return logical.ErrorResponse("invalid document: %s", err), nil
+	}
+	b.importLock.Lock()
+	defer b.importLock.Unlock()
+	current, err := loadDocument(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := getPolicy(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	final, skipped, err := buildImport(current, doc, mode, policy, config, b.System())
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	diff := importDiff{
+		"roles":             diffEntries(current.Roles, final.Roles),
+		"role_templates":    diffEntries(current.RoleTemplates, final.RoleTemplates),
+		"integration_roles": diffEntries(current.IntegrationRoles, final.IntegrationRoles),
+		"elevation_roles":   diffEntries(current.ElevationRoles, final.ElevationRoles),
+		"scope_sets":        diffEntries(current.ScopeSets, final.ScopeSets),
+	}
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"mode":    mode,
+			"dry_run": d.Get("dry_run").(bool),
+			"changes": diff,
+		},
+	}
+	if len(doc.Config) > 0 {
+		resp.AddWarning("config is exported for reference only and was not imported")
+	}
+	if len(skipped) > 0 {
+		sort.Strings(skipped)
+		resp.Data["skipped"] = map[string][]string{
+			"integration_roles": skipped,
+		}
+		resp.AddWarning(fmt.Sprintf("integration roles without credentials were skipped, write them with credentials or credentials_path: %s", strings.Join(skipped, ", ")))
+	}
+	if d.Get("dry_run").(bool) {
+		return resp, nil
+	}
+	// the history of the updated and removed roles is restored if the import fails
+	versions, err := snapshotRoleVersions(ctx, req.Storage, append(diff["roles"]["updated"], diff["roles"]["removed"]...))
+	if err != nil {
+		return nil, err
+	}
+	if err := b.applyImport(ctx, req, current, final, diff); err != nil {
+		if restoreErr := b.restoreImport(ctx, req.Storage, current, diff, versions); restoreErr != nil {
+			b.Logger().Error("error while restoring roles after failed import", "error", restoreErr)
+		}
+		return nil, err
+	}
+	for _, name := range diff["roles"]["removed"] {
+		if err := b.deleteRoleUsage(ctx, req.Storage, name); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+const exportHelpSyn = "Export the roles and the non-secret config of the engine."
+const exportHelpDesc = `
+This path returns a versioned JSON or YAML document with every token role,
+role template, scope set, integration role and elevation role, and the
+non-secret config settings. Integration credentials and root credentials are never
+exported.
+`
+
+const importHelpSyn = "Import the roles exported from the engine."
+const importHelpDesc = `
+This path validates the whole document before applying it. In the merge
+mode the roles from the document are added or updated, in the replace mode
+the roles missing in the document are deleted as well. With dry_run the
+changes are returned without being applied. If applying fails, the roles and
+the version history of the updated and removed roles are restored to their
+previous state.
+
+Imported integration roles without credentials keep the credentials of the
+existing role. The integration roles without credentials, credentials_path or
+an existing role are skipped with a warning. The config is not imported.
+`
@@ -0,0 +1,100 @@
+package buddysecrets
+
+import (
+	"context"
+	"github.com/hashicorp/vault/sdk/logical"
+	"slices"
+	"testing"
+)
+
+// testCredentialsPathPrefixes configures the mount allowed to read the credentials from the prefixes
+func testCredentialsPathPrefixes(t *testing.T, b *buddySecretBackend, s logical.Storage, f *fakeBuddy, prefixes string) {
+	t.Helper()
+	testConfigure(t, b, s, f)
+	testOk(t, b, s, logical.UpdateOperation, "config", map[string]interface{}{
+		"credentials_path_prefixes": prefixes,
+	})
+}
+
+func TestExportImportIntoNewMount(t *testing.T) {
+	f := newFakeBuddy(t)
+	b, s := getTestBackend(t, 0)
+	testCredentialsPathPrefixes(t, b, s, f, "secret/data/")
+	testOk(t, b, s, logical.CreateOperation, "roles/r1", map[string]interface{}{
+		"scopes": "WORKSPACE",
+		"ttl":    "1h",
+	})
+	testOk(t, b, s, logical.CreateOperation, "integration-roles/inline", map[string]interface{}{
+		"workspace":   "acme",
+		"type":        "AMAZON",
+		"credentials": "access_key=key,secret_key=secret",
+	})
+	testOk(t, b, s, logical.CreateOperation, "integration-roles/from-path", map[string]interface{}{
+		"workspace":        "acme",
+		"type":             "AMAZON",
+		"credentials_path": "secret/data/aws",
+	})
+	exported := testOk(t, b, s, logical.ReadOperation, "export", nil)
+	document := exported.Data["document"].(string)
+
+	nb, ns := getTestBackend(t, 0)
+	// the new mount does not allow the credentials_path yet
+	resp := testRequest(t, nb, ns, logical.UpdateOperation, "import", map[string]interface{}{
+		"document": document,
+	})
+	if !resp.IsError() {
+		t.Fatal("integration role with credentials_path outside of credentials_path_prefixes must not be imported")
+	}
+	testCredentialsPathPrefixes(t, nb, ns, f, "secret/data/")
+	dryRun := testOk(t, nb, ns, logical.UpdateOperation, "import", map[string]interface{}{
+		"document": document,
+		"dry_run":  true,
+	})
+	skipped := dryRun.Data["skipped"].(map[string][]string)["integration_roles"]
+	if !slices.Equal(skipped, []string{"inline"}) {
+		t.Fatalf("expected the inline integration role to be skipped, got %v", skipped)
+	}
+	if len(dryRun.Warnings) == 0 {
+		t.Fatal("skipped roles must be reported in a warning")
+	}
+	testOk(t, nb, ns, logical.UpdateOperation, "import", map[string]interface{}{
+		"document": document,
+	})
+	if resp := testOk(t, nb, ns, logical.ReadOperation, "roles/r1", nil); resp == nil {
+		t.Fatal("role r1 must be imported")
+	}
+	if resp := testOk(t, nb, ns, logical.ReadOperation, "integration-roles/from-path", nil); resp == nil {
+		t.Fatal("integration role with credentials_path must be imported")
+	}
+	if resp := testOk(t, nb, ns, logical.ReadOperation, "integration-roles/inline", nil); resp != nil {
+		t.Fatal("integration role without credentials must not be imported")
+	}
+}
+
+func TestImportRestoresRoleVersions(t *testing.T) {
+	b, s := getTestBackend(t, 0)
+	testOk(t, b, s, logical.CreateOperation, "roles/r1", map[string]interface{}{
+		"scopes": "WORKSPACE",
+	})
+	testOk(t, b, s, logical.UpdateOperation, "roles/r1", map[string]interface{}{
+		"ttl": "1h",
+	})
+	ctx := context.Background()
+	snapshot, err := snapshotRoleVersions(ctx, s, []string{"r1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := deleteRoleVersions(ctx, s, "r1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := restoreRoleVersions(ctx, s, snapshot); err != nil {
+		t.Fatal(err)
+	}
+	versions, err := listRoleVersions(ctx, s, "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(versions, []int{1, 2}) {
+		t.Fatalf("expected versions [1 2], got %v", versions)
+	}
+}
@@ -0,0 +1,208 @@
+package buddysecrets
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/buddy/api-go-sdk/buddy"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	healthTimeout = 10 * time.Second
+)
+
+func pathHealth(b *buddySecretBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "health",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback:                  b.pathHealthRead,
+				ForwardPerformanceStandby: true,
+			},
+		},
+		HelpSynopsis:    healthHelpSyn,
+		HelpDescription: healthHelpDesc,
+	}
+}
+
+// probeBaseUrl checks that the Buddy API answers on base_url and returns the TLS details of the connection
+func probeBaseUrl(config *buddyConfig) (map[string]interface{}, error) {
+	h := newHttpClient(config.Insecure, healthTimeout)
+	res, err := h.Get(strings.TrimSuffix(config.BaseUrl, "/"))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.TLS == nil {
+		return nil, nil
+	}
+	info := map[string]interface{}{
+		"version":      tls.VersionName(res.TLS.Version),
+		"cipher_suite": tls.CipherSuiteName(res.TLS.CipherSuite),
+		"server_name":  res.TLS.ServerName,
+		"verified":     !config.Insecure,
+	}
+	if len(res.TLS.PeerCertificates) > 0 {
+		cert := res.TLS.PeerCertificates[0]
+		info["certificate_subject"] = cert.Subject.String()
+		info["certificate_issuer"] = cert.Issuer.String()
+		info["certificate_expires_at"] = cert.NotAfter
+	}
+	return info, nil
+}
+
+// missingScopes returns the scopes of the effective roles which the root token does not have. Roles
+// issued by the workspace root tokens are checked against the scopes of their workspace root token
+func missingScopes(ctx context.Context, s logical.Storage, config *buddyConfig, rootScopes []string) (map[string][]string, error) {
+	names, err := s.List(ctx, rolesStoragePath+"/")
+	if err != nil {
+		return nil, err
+	}
+	missing := map[string][]string{}
+	for _, name := range names {
+		role, err := getEffectiveRole(ctx, name, s)
+		if err != nil {
+			// broken roles are reported when credentials are requested
+			continue
+		}
+		if role == nil {
+			continue
+		}
+		workspace, err := config.roleWorkspace(role)
+		if err != nil {
+			// roles without a matching workspace root token are reported when credentials are requested
+			continue
+		}
+		scopes := rootScopes
+		if workspace != "" {
+			scopes = config.WorkspaceTokens[workspace].TokenScopes
+		}
+		for _, scope := range role.Scopes {
+			if !slices.Contains(scopes, scope) {
+				missing[name] = append(missing[name], scope)
+			}
+		}
+	}
+	return missing, nil
+}
+
+func (b *buddySecretBackend) pathHealthRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil || !config.hasRootCredential() {
+		return logical.ErrorResponse("root token not provided through config"), nil
+	}
+	healthy := true
+	data := map[string]interface{}{
+		"base_url": config.BaseUrl,
+	}
+	tlsInfo, err := probeBaseUrl(config)
+	data["base_url_reachable"] = err == nil
+	if err != nil {
+		healthy = false
+		data["base_url_error"] = config.redact(err.Error())
+	}
+	if tlsInfo != nil {
+		data["tls"] = tlsInfo
+	}
+	start := time.Now()
+	var token *buddy.Token
+	client, err := b.getClient(ctx, req.Storage)
+	if err == nil {
+		token, err = client.GetRootToken()
+	}
+	data["latency_ms"] = time.Since(start).Milliseconds()
+	data["token_valid"] = err == nil
+	if err != nil {
+		healthy = false
+		data["status"] = apiStatus(nil, err)
+		data["token_error"] = config.redact(err.Error())
+		data["healthy"] = healthy
+		return &logical.Response{Data: data}, nil
+	}
+	data["status"] = http.StatusOK
+	data["token_id"] = token.Id
+	data["token_scopes"] = token.Scopes
+	data["token_ip_restrictions"] = token.IpRestrictions
+	data["token_workspace_restrictions"] = token.WorkspaceRestrictions
+	if expiresAt, err := time.Parse(time.RFC3339, token.ExpiresAt); err == nil {
+		ttl := time.Until(expiresAt)
+		data["token_expires_at"] = expiresAt
+		data["token_ttl"] = int64(ttl.Seconds())
+		if ttl <= 0 {
+			healthy = false
+		}
+	} else {
+		data["token_expires_at"] = "no expiration date"
+	}
+	data["token_manage_scope"] = hasManageScope(token.Scopes)
+	if !hasManageScope(token.Scopes) {
+		healthy = false
+	}
+	if len(config.WorkspaceTokens) > 0 {
+		workspaces := map[string]interface{}{}
+		for _, domain := range config.workspaceDomains() {
+			workspace, ok := b.workspaceHealth(ctx, req.Storage, config, domain)
+			if !ok {
+				healthy = false
+			}
+			workspaces[domain] = workspace
+		}
+		data["workspace_tokens"] = workspaces
+	}
+	missing, err := missingScopes(ctx, req.Storage, config, token.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	data["roles_missing_scopes"] = missing
+	if len(missing) > 0 {
+		healthy = false
+	}
+	data["healthy"] = healthy
+	return &logical.Response{Data: data}, nil
+}
+
+// workspaceHealth calls Buddy with the root token of the workspace and reports whether it is valid
+func (b *buddySecretBackend) workspaceHealth(ctx context.Context, s logical.Storage, config *buddyConfig, domain string) (map[string]interface{}, bool) {
+	var token *buddy.Token
+	client, err := b.getWorkspaceClient(ctx, s, domain)
+	if err == nil {
+		token, err = client.GetRootToken()
+	}
+	data := map[string]interface{}{
+		"token_valid": err == nil,
+	}
+	if err != nil {
+		data["status"] = apiStatus(nil, err)
+		data["token_error"] = config.redact(err.Error())
+		return data, false
+	}
+	data["status"] = http.StatusOK
+	data["token_id"] = token.Id
+	data["token_manage_scope"] = hasManageScope(token.Scopes)
+	healthy := hasManageScope(token.Scopes)
+	if expiresAt, err := time.Parse(time.RFC3339, token.ExpiresAt); err == nil {
+		ttl := time.Until(expiresAt)
+		data["token_ttl"] = int64(ttl.Seconds())
+		if ttl <= 0 {
+			healthy = false
+		}
+	}
+	return data, healthy
+}
+
+const healthHelpSyn = "Check the connection to Buddy and the root token."
+const healthHelpDesc = `
+This path calls Buddy with the root token and reports the latency, the validity,
+the remaining lifetime and the scopes of the token, the roles requesting scopes
+the token does not have, the validity of the workspace root tokens, and the
+reachability and TLS details of base_url.
+The token itself is never returned.
+`
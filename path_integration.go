@@ -0,0 +1,264 @@
+package buddysecrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/armon/go-metrics"
+	"github.com/buddy/api-go-sdk/buddy"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/base62"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	SecretTypeIntegration = "integration"
+)
+
+func secretIntegration(b *buddySecretBackend) *framework.Secret {
+	return &framework.Secret{
+		Type:   SecretTypeIntegration,
+		Renew:  b.integrationRenew,
+		Revoke: b.integrationRevoke,
+	}
+}
+
+func (b *buddySecretBackend) integrationRenew(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	roleRaw, ok := req.Secret.InternalData["role"]
+	if !ok {
+		return nil, fmt.Errorf("internal data 'role' not found")
+	}
+	role, err := getIntegrationRole(ctx, roleRaw.(string), req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, nil
+	}
+	resp := &logical.Response{Secret: req.Secret}
+	resp.Secret.TTL = role.Ttl
+	resp.Secret.MaxTTL = role.MaxTTL
+	return resp, nil
+}
+
+func (b *buddySecretBackend) integrationRevoke(ctx context.Context, req *logical.Request, _ *framework.FieldData) (_ *logical.Response, err error) {
+	defer func() {
+		roleName, _ := req.Secret.InternalData["role"].(string)
+		incrCounter([]string{"integration", "revoked"},
+			metrics.Label{Name: "role", Value: roleName},
+			metrics.Label{Name: "status", Value: metricsStatus(err)})
+	}()
+	workspaceRaw, ok := req.Secret.InternalData["workspace"]
+	if !ok {
+		return nil, fmt.Errorf("internal data 'workspace' not found")
+	}
+	hashIdsRaw, ok := req.Secret.InternalData["hash_ids"]
+	if !ok {
+		return nil, fmt.Errorf("internal data 'hash_ids' not found")
+	}
+	client, err := b.getClient(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	var hashIds []string
+	switch raw := hashIdsRaw.(type) {
+	case []string:
+		hashIds = raw
+	case []interface{}:
+		// internal data is round-tripped through JSON when the lease is stored
+		for _, hashId := range raw {
+			hashIdStr, ok := hashId.(string)
+			if !ok {
+				return nil, fmt.Errorf("internal data 'hash_ids' has unexpected value %v", hashId)
+			}
+			hashIds = append(hashIds, hashIdStr)
+		}
+	default:
+		return nil, fmt.Errorf("internal data 'hash_ids' has unexpected type %T", hashIdsRaw)
+	}
+	// every integration is deleted even if some fail, the revocation is retried for the rest
+	var errs []error
+	for _, hashId := range hashIds {
+		err = client.DeleteIntegration(workspaceRaw.(string), hashId)
+		// integration could have been already deleted in buddy or by the previous attempt
+		if err != nil && !isNotFound(err) {
+			b.requestLogger(req).Error("error while deleting integration", "operation", "delete_integration", "hash_id", hashId, "status", apiStatus(nil, err), "error", err)
+			errs = append(errs, err)
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// readVaultCredentials reads the integration credentials from another Vault path using the token saved in config.
+// The path is checked again, the prefixes could have changed since the role was written
+func readVaultCredentials(ctx context.Context, config *buddyConfig, path string) (map[string]string, error) {
+	if err := checkCredentialsPath(config, path); err != nil {
+		return nil, err
+	}
+	vaultClient, err := newVaultClient(config)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := vaultClient.Logical().ReadWithContext(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, fmt.Errorf("no credentials found at '%s'", path)
+	}
+	data := secret.Data
+	// kv version 2 nests the values under the data key
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested
+	}
+	credentials := map[string]string{}
+	for key, value := range data {
+		if str, ok := value.(string); ok {
+			credentials[key] = str
+		}
+	}
+	return credentials, nil
+}
+
+func newIntegrationOps(credentials map[string]string) (*buddy.IntegrationOps, error) {
+	if err := validateIntegrationCredentials(credentials); err != nil {
+		return nil, err
+	}
+	// credential keys match the json names of the api ops
+	raw, err := json.Marshal(credentials)
+	if err != nil {
+		return nil, err
+	}
+	ops := new(buddy.IntegrationOps)
+	if err := json.Unmarshal(raw, ops); err != nil {
+		return nil, err
+	}
+	return ops, nil
+}
+
+func (b *buddySecretBackend) pathIntegrationRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil || !config.hasRootCredential() {
+		return logical.ErrorResponse("root token not provided through config"), nil
+	}
+	client, err := b.getClient(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	roleName := d.Get("role").(string)
+	role, err := getIntegrationRole(ctx, roleName, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("integration role '%s' does not exist", roleName)), nil
+	}
+	credentials := map[string]string{}
+	for key, value := range role.Credentials {
+		credentials[key] = value
+	}
+	if role.CredentialsPath != "" {
+		pathCredentials, err := readVaultCredentials(ctx, config, role.CredentialsPath)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range pathCredentials {
+			credentials[key] = value
+		}
+	}
+	suffix, err := base62.Random(8)
+	if err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("vault-%s-%s", roleName, suffix)
+	var integrations []*buddy.Integration
+	// deletes already created integrations if one of the next ones fails
+	rollback := func() {
+		incrCounter([]string{"integration", "rollback"}, metrics.Label{Name: "role", Value: roleName})
+		for _, i := range integrations {
+			_ = client.DeleteIntegration(role.Workspace, i.HashId)
+		}
+	}
+	projects := role.Projects
+	if len(projects) == 0 {
+		projects = []string{""}
+	}
+	for _, project := range projects {
+		ops, err := newIntegrationOps(credentials)
+		if err != nil {
+			rollback()
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		integrationName := name
+		integrationType := role.Type
+		scope := buddy.IntegrationScopeWorkspace
+		ops.Name = &integrationName
+		ops.Type = &integrationType
+		ops.Scope = &scope
+		if project != "" {
+			projectName := project
+			scope = buddy.IntegrationScopeProject
+			ops.ProjectName = &projectName
+		}
+		integration, err := client.CreateIntegration(role.Workspace, ops)
+		if err != nil {
+			rollback()
+			return nil, err
+		}
+		integrations = append(integrations, integration)
+	}
+	hashIds := make([]string, 0, len(integrations))
+	list := make([]map[string]interface{}, 0, len(integrations))
+	for _, i := range integrations {
+		hashIds = append(hashIds, i.HashId)
+		list = append(list, map[string]interface{}{
+			"hash_id":      i.HashId,
+			"name":         i.Name,
+			"project_name": i.ProjectName,
+		})
+	}
+	data := map[string]interface{}{
+		"workspace":    role.Workspace,
+		"integrations": list,
+	}
+	internalData := map[string]interface{}{
+		"role":      roleName,
+		"workspace": role.Workspace,
+		"hash_ids":  hashIds,
+	}
+	resp := b.Secret(SecretTypeIntegration).Response(data, internalData)
+	resp.Secret.TTL = role.Ttl
+	resp.Secret.MaxTTL = role.MaxTTL
+	return resp, nil
+}
+
+func pathIntegration(b *buddySecretBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: fmt.Sprintf("integration-creds/%s", framework.GenericNameRegex("role")),
+		Fields: map[string]*framework.FieldSchema{
+			"role": {
+				Type:        framework.TypeLowerCaseString,
+				Description: "The name of the Vault integration role",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback:                    b.pathIntegrationRead,
+				ForwardPerformanceSecondary: true,
+				ForwardPerformanceStandby:   true,
+			},
+		},
+		HelpSynopsis:    integrationHelpSyn,
+		HelpDescription: integrationHelpDesc,
+	}
+}
+
+const integrationHelpSyn = "Request Buddy integration for the given Vault integration role."
+const integrationHelpDesc = `
+This path creates the short-lived Buddy integration in the workspace.
+It will be automatically deleted when the lease time has expired.
+`
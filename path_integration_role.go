@@ -0,0 +1,277 @@
+package buddysecrets
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"sort"
+	"time"
+)
+
+const (
+	integrationRolesStoragePath = "integration-roles"
+)
+
+// integrationCredentialKeys are the credential fields accepted by the Buddy integration API
+var integrationCredentialKeys = []string{
+	"access_key",
+	"api_key",
+	"app_id",
+	"audience",
+	"auth_type",
+	"config",
+	"email",
+	"google_project",
+	"partner_token",
+	"password",
+	"secret_key",
+	"shop",
+	"tenant_id",
+	"token",
+	"username",
+}
+
+type integrationRoleEntry struct {
+	Ttl             time.Duration     `json:"ttl"`
+	MaxTTL          time.Duration     `json:"max_ttl"`
+	Workspace       string            `json:"workspace"`
+	Type            string            `json:"type"`
+	Projects        []string          `json:"projects"`
+	Credentials     map[string]string `json:"credentials"`
+	CredentialsPath string            `json:"credentials_path"`
+}
+
+func pathIntegrationRole(b *buddySecretBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "integration-roles/" + framework.GenericNameRegex("name"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeLowerCaseString,
+				Description: "The name of the integration role",
+			},
+			"ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "The default lease time for the generated integration after which the integration is automatically removed. If not set or set to 0, system default is used.",
+			},
+			"max_ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "The maximum time the generated integration can be extended to before it eventually expires. If not set or set to 0, system default is used.",
+			},
+			"workspace": {
+				Type:        framework.TypeString,
+				Description: "The domain of the workspace in which the integration is created. Required",
+			},
+			"type": {
+				Type:        framework.TypeString,
+				Description: "The type of the integration, e.g. `AMAZON`, `GOOGLE_SERVICE_ACCOUNT`, `DOCKER_HUB`. Required",
+			},
+			"projects": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "The list of project names to which the integration is scoped, comma-separated. One integration is created per project. If not set, the integration is available in the whole workspace.",
+			},
+			"credentials": {
+				Type:        framework.TypeKVPairs,
+				Description: "The credentials of the integration, e.g. `access_key=...,secret_key=...`.",
+			},
+			"credentials_path": {
+				Type:        framework.TypeString,
+				Description: "The Vault path from which the credentials are read when the integration is created. Values read from the path take precedence over `credentials`. Must start with one of `credentials_path_prefixes` of the config.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathIntegrationRoleRead,
+			},
+			logical.CreateOperation: &framework.PathOperation{
+				Callback:                    b.pathIntegrationRoleWrite,
+				ForwardPerformanceSecondary: true,
+				ForwardPerformanceStandby:   true,
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback:                    b.pathIntegrationRoleWrite,
+				ForwardPerformanceSecondary: true,
+				ForwardPerformanceStandby:   true,
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback:                    b.pathIntegrationRoleDelete,
+				ForwardPerformanceSecondary: true,
+				ForwardPerformanceStandby:   true,
+			},
+		},
+		ExistenceCheck:  b.pathIntegrationRoleExistenceCheck,
+		HelpSynopsis:    integrationRoleHelpSyn,
+		HelpDescription: integrationRoleHelpDesc,
+	}
+}
+
+func saveIntegrationRole(ctx context.Context, s logical.Storage, c *integrationRoleEntry, name string) error {
+	sort.Strings(c.Projects)
+	entry, err := secretEntryJSON(fmt.Sprintf("%s/%s", integrationRolesStoragePath, name), c)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+func getIntegrationRole(ctx context.Context, name string, s logical.Storage) (*integrationRoleEntry, error) {
+	entry, err := s.Get(ctx, fmt.Sprintf("%s/%s", integrationRolesStoragePath, name))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	role := new(integrationRoleEntry)
+	if err := entry.DecodeJSON(role); err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+func (r *integrationRoleEntry) validate() error {
+	if r.MaxTTL != 0 && r.Ttl > r.MaxTTL {
+		return fmt.Errorf("ttl cannot be greater than max_ttl")
+	}
+	if r.Workspace == "" {
+		return fmt.Errorf("workspace must be provided")
+	}
+	if r.Type == "" {
+		return fmt.Errorf("type must be provided")
+	}
+	if len(r.Credentials) == 0 && r.CredentialsPath == "" {
+		return fmt.Errorf("credentials or credentials_path must be provided")
+	}
+	return validateIntegrationCredentials(r.Credentials)
+}
+
+func validateIntegrationCredentials(credentials map[string]string) error {
+	for key := range credentials {
+		valid := false
+		for _, k := range integrationCredentialKeys {
+			if k == key {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return fmt.Errorf("unsupported credential '%s'", key)
+		}
+	}
+	return nil
+}
+
+func (b *buddySecretBackend) pathIntegrationRoleExistenceCheck(ctx context.Context, req *logical.Request, d *framework.FieldData) (bool, error) {
+	name := d.Get("name").(string)
+	role, err := getIntegrationRole(ctx, name, req.Storage)
+	if err != nil {
+		return false, err
+	}
+	return role != nil, nil
+}
+
+func (b *buddySecretBackend) pathIntegrationRoleDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	err := req.Storage.Delete(ctx, fmt.Sprintf("%s/%s", integrationRolesStoragePath, name))
+	return nil, err
+}
+
+func (b *buddySecretBackend) pathIntegrationRoleRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	role, err := getIntegrationRole(ctx, name, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, nil
+	}
+	credentialKeys := make([]string, 0, len(role.Credentials))
+	for key := range role.Credentials {
+		credentialKeys = append(credentialKeys, key)
+	}
+	sort.Strings(credentialKeys)
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"ttl":              role.Ttl.Seconds(),
+			"max_ttl":          role.MaxTTL.Seconds(),
+			"workspace":        role.Workspace,
+			"type":             role.Type,
+			"projects":         role.Projects,
+			"credential_keys":  credentialKeys,
+			"credentials_path": role.CredentialsPath,
+		},
+	}
+	return resp, nil
+}
+
+func (b *buddySecretBackend) pathIntegrationRoleWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	role, err := getIntegrationRole(ctx, name, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		if req.Operation == logical.UpdateOperation {
+			return logical.ErrorResponse("integration role not found during update operation"), nil
+		}
+		role = &integrationRoleEntry{}
+	}
+	if ttl, ok := d.GetOk("ttl"); ok {
+		role.Ttl = time.Duration(ttl.(int)) * time.Second
+	} else if req.Operation == logical.CreateOperation {
+		role.Ttl = time.Duration(d.Get("ttl").(int)) * time.Second
+	}
+	if maxTtl, ok := d.GetOk("max_ttl"); ok {
+		role.MaxTTL = time.Duration(maxTtl.(int)) * time.Second
+	} else if req.Operation == logical.CreateOperation {
+		role.MaxTTL = time.Duration(d.Get("max_ttl").(int)) * time.Second
+	}
+	if workspace, ok := d.GetOk("workspace"); ok {
+		role.Workspace = workspace.(string)
+	}
+	if integrationType, ok := d.GetOk("type"); ok {
+		role.Type = integrationType.(string)
+	}
+	if projects, ok := d.GetOk("projects"); ok {
+		role.Projects = projects.([]string)
+	}
+	if credentials, ok := d.GetOk("credentials"); ok {
+		role.Credentials = credentials.(map[string]string)
+	}
+	if credentialsPath, ok := d.GetOk("credentials_path"); ok {
+		role.CredentialsPath = credentialsPath.(string)
+	}
+	if err := role.validate(); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if role.CredentialsPath != "" {
+		config, err := b.getConfig(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkCredentialsPath(config, role.CredentialsPath); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+	if role.Projects == nil {
+		role.Projects = []string{}
+	}
+	if role.Credentials == nil {
+		role.Credentials = map[string]string{}
+	}
+	err = saveIntegrationRole(ctx, req.Storage, role, name)
+	return nil, err
+}
+
+const integrationRoleHelpSyn = "Manage the Vault roles used to generate Buddy integrations."
+
+const integrationRoleHelpDesc = `
+This path allows you to read and write roles that are used to generate
+Buddy integrations. If the backend is mounted at "buddy", you would create
+a Vault role at "buddy/integration-roles/my_role" and request credentials
+from "buddy/integration-creds/my_role".
+
+When a user requests credentials against the Vault role, a new integration
+is created in the workspace from the credentials saved in the role or read
+from "credentials_path". The integration is removed when the lease expires.
+`
@@ -0,0 +1,31 @@
+package buddysecrets
+
+import (
+	"context"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathIntegrationRoles(b *buddySecretBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "integration-roles/?",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ListOperation: &framework.PathOperation{
+				Callback: b.pathIntegrationRolesList,
+			},
+		},
+		HelpSynopsis:    integrationRolesHelpSyn,
+		HelpDescription: integrationRolesHelpDesc,
+	}
+}
+
+func (b *buddySecretBackend) pathIntegrationRolesList(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	roles, err := req.Storage.List(ctx, integrationRolesStoragePath+"/")
+	if err != nil {
+		return nil, err
+	}
+	return logical.ListResponse(roles), nil
+}
+
+const integrationRolesHelpSyn = "List existing integration roles."
+const integrationRolesHelpDesc = "List existing integration roles by name."
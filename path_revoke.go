@@ -0,0 +1,162 @@
+package buddysecrets
+
+import (
+	"context"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathRevoke(b *buddySecretBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "roles/" + framework.GenericNameRegex("name") + "/revoke-all",
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "The name of the role",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback:                  b.pathRoleRevokeAll,
+					ForwardPerformanceStandby: true,
+				},
+			},
+			HelpSynopsis:    roleRevokeAllHelpSyn,
+			HelpDescription: roleRevokeAllHelpDesc,
+		},
+		{
+			Pattern: "revoke-orphans",
+			Fields: map[string]*framework.FieldSchema{
+				"root_token_id": {
+					Type:        framework.TypeString,
+					Description: "The ID of the previous root token. If not set, tokens created by any root token other than the current ones (the root credentials and the workspace root tokens) are revoked",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback:                  b.pathRevokeOrphans,
+					ForwardPerformanceStandby: true,
+				},
+			},
+			HelpSynopsis:    revokeOrphansHelpSyn,
+			HelpDescription: revokeOrphansHelpDesc,
+		},
+	}
+}
+
+// revokeIssuedTokens deletes the Buddy tokens, removes their records and releases their leases.
+// It returns the IDs of the revoked tokens
+func (b *buddySecretBackend) revokeIssuedTokens(ctx context.Context, s logical.Storage, tokens []*issuedToken) ([]string, error) {
+	revoked := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		// the token is deleted by the root token of the workspace which created it
+		err := b.deleteWorkspaceToken(ctx, s, token.RootWorkspace, token.TokenId)
+		if err != nil && !isNotFound(err) {
+			return revoked, err
+		}
+		if err := deleteIssuedToken(ctx, s, token.TokenId); err != nil {
+			return revoked, err
+		}
+		if err := b.releaseLease(ctx, s, token.Role, token.EntityId); err != nil {
+			return revoked, err
+		}
+		revoked = append(revoked, token.TokenId)
+	}
+	return revoked, nil
+}
+
+func (b *buddySecretBackend) pathRoleRevokeAll(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil || !config.hasRootCredential() {
+		return logical.ErrorResponse("root token not provided through config"), nil
+	}
+	name := d.Get("name").(string)
+	tokens, err := listIssuedTokens(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	revoked, err := b.revokeIssuedTokens(ctx, req.Storage, tokens)
+	if err != nil {
+		return nil, err
+	}
+	leasesRevoked := false
+	if config.VaultToken != "" {
+		vaultClient, err := newVaultClient(config)
+		if err != nil {
+			return nil, err
+		}
+		err = vaultClient.Sys().RevokePrefixWithContext(ctx, req.MountPoint+"creds/"+name)
+		if err != nil {
+			return nil, err
+		}
+		leasesRevoked = true
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"revoked_token_ids": revoked,
+			"leases_revoked":    leasesRevoked,
+		},
+	}, nil
+}
+
+func (b *buddySecretBackend) pathRevokeOrphans(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil || !config.hasRootCredential() {
+		return logical.ErrorResponse("root token not provided through config"), nil
+	}
+	rootTokenId := d.Get("root_token_id").(string)
+	if rootTokenId != "" && config.isRootTokenId(rootTokenId) {
+		return logical.ErrorResponse("root_token_id is the ID of the current root token"), nil
+	}
+	tokens, err := listIssuedTokens(ctx, req.Storage, "")
+	if err != nil {
+		return nil, err
+	}
+	var orphans []*issuedToken
+	for _, token := range tokens {
+		if rootTokenId != "" && token.RootTokenId != rootTokenId {
+			continue
+		}
+		// records written before the root token was recorded cannot be attributed to any root token
+		if token.RootTokenId == "" {
+			continue
+		}
+		if config.isRootTokenId(token.RootTokenId) {
+			continue
+		}
+		orphans = append(orphans, token)
+	}
+	revoked, err := b.revokeIssuedTokens(ctx, req.Storage, orphans)
+	if err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"revoked_token_ids": revoked,
+		},
+	}, nil
+}
+
+const roleRevokeAllHelpSyn = "Revoke every Buddy token issued for the role."
+const roleRevokeAllHelpDesc = `
+This path deletes every Buddy token issued for the role and tracked by the
+engine. If vault_token is configured, the Vault leases of the role are
+revoked as well, otherwise they expire on their own. With performance
+replication, only the tokens issued by this cluster are revoked; call it
+on every cluster.
+`
+
+const revokeOrphansHelpSyn = "Revoke the Buddy tokens created by a previous root token."
+const revokeOrphansHelpDesc = `
+This path deletes the issued Buddy tokens which were created by a root token
+other than the current ones, including the workspace root tokens, or by the
+given root_token_id only. With performance replication, only the tokens
+issued by this cluster are revoked; call it on every cluster.
+`
@@ -0,0 +1,60 @@
+package buddysecrets
+
+import (
+	"context"
+	"github.com/hashicorp/vault/sdk/logical"
+	"testing"
+	"time"
+)
+
+func TestRevokeOrphans(t *testing.T) {
+	f := newFakeBuddy(t)
+	b, s := getTestBackend(t, 0)
+	root := testConfigure(t, b, s, f)
+	ctx := context.Background()
+	issue := func(rootTokenId string) string {
+		token := f.addRootToken()
+		err := saveIssuedToken(ctx, s, &issuedToken{
+			TokenId:     token.Id,
+			Role:        "r1",
+			CreatedAt:   time.Now(),
+			RootTokenId: rootTokenId,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token.Id
+	}
+	current := issue(root.Id)
+	// issued before the root token was recorded
+	legacy := issue("")
+	orphan := issue("previous-root")
+
+	resp := testOk(t, b, s, logical.UpdateOperation, "revoke-orphans", nil)
+	revoked := resp.Data["revoked_token_ids"].([]string)
+	if len(revoked) != 1 || revoked[0] != orphan {
+		t.Fatalf("expected only %s to be revoked, got %v", orphan, revoked)
+	}
+	if f.exists(orphan) {
+		t.Fatal("orphan must be deleted in buddy")
+	}
+	for _, id := range []string{current, legacy} {
+		if !f.exists(id) {
+			t.Fatalf("token %s must not be deleted in buddy", id)
+		}
+		issued, err := getIssuedToken(ctx, id, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if issued == nil {
+			t.Fatalf("record of token %s must be kept", id)
+		}
+	}
+
+	resp = testRequest(t, b, s, logical.UpdateOperation, "revoke-orphans", map[string]interface{}{
+		"root_token_id": root.Id,
+	})
+	if !resp.IsError() {
+		t.Fatal("revoking the tokens of the current root token must fail")
+	}
+}
@@ -11,14 +11,37 @@ import (
 
 const (
 	rolesStoragePath = "roles"
+	// the token is returned in the response
+	roleDeliveryDirect = "direct"
+	// the token is returned in the response wrapped by the engine, so it can be read only once
+	roleDeliveryWrapped = "wrapped"
 )
 
 type roleEntry struct {
-	Ttl                   time.Duration `json:"ttl"`
-	MaxTTL                time.Duration `json:"max_ttl"`
-	Scopes                []string      `json:"scopes"`
-	IpRestrictions        []string      `json:"ip_restrictions"`
-	WorkspaceRestrictions []string      `json:"workspace_restrictions"`
+	Ttl                      time.Duration     `json:"ttl"`
+	MaxTTL                   time.Duration     `json:"max_ttl"`
+	Scopes                   []string          `json:"scopes"`
+	IpRestrictions           []string          `json:"ip_restrictions"`
+	WorkspaceRestrictions    []string          `json:"workspace_restrictions"`
+	PoolSize                 int               `json:"pool_size"`
+	MaxActiveLeases          int               `json:"max_active_leases"`
+	MaxActiveLeasesPerEntity int               `json:"max_active_leases_per_entity"`
+	IssueRate                int               `json:"issue_rate"`
+	Description              string            `json:"description"`
+	Owner                    string            `json:"owner"`
+	Tags                     map[string]string `json:"tags"`
+	CreatedAt                time.Time         `json:"created_at"`
+	UpdatedAt                time.Time         `json:"updated_at"`
+	UpdatedBy                string            `json:"updated_by"`
+	Version                  int               `json:"version"`
+	MaxVersions              int               `json:"max_versions"`
+	Template                 string            `json:"template"`
+	WrapTTL                  time.Duration     `json:"wrap_ttl"`
+	Delivery                 string            `json:"delivery"`
+	BindToClientIp           bool              `json:"bind_to_client_ip"`
+	ClientIpPrefix           int               `json:"client_ip_prefix"`
+	ClientIpv6Prefix         int               `json:"client_ipv6_prefix"`
+	SchemaVersion            int               `json:"schema_version"`
 }
 
 func pathRole(b *buddySecretBackend) *framework.Path {
@@ -39,7 +62,7 @@ func pathRole(b *buddySecretBackend) *framework.Path {
 			},
 			"scopes": {
 				Type:        framework.TypeCommaStringSlice,
-				Description: "The list of scopes in the role, comma-separated.",
+				Description: "The list of scopes in the role, comma-separated. Scope sets are referenced as @name, e.g. @ci-runner. Scopes are upper-cased, scope set names lower-cased.",
 			},
 			"ip_restrictions": {
 				Type:        framework.TypeCommaStringSlice,
@@ -49,19 +72,83 @@ func pathRole(b *buddySecretBackend) *framework.Path {
 				Type:        framework.TypeCommaStringSlice,
 				Description: "The list of workspace domains to which the token is restrictred, comma-separated.",
 			},
+			"pool_size": {
+				Type:        framework.TypeInt,
+				Description: "The number of pre-created tokens kept ready for the role, so credentials are returned without calling the Buddy API. Every pooled token is deleted from Buddy whenever the mount is unloaded, also on reload, seal or leadership change, and the pool is refilled by the next periodic run. Default: 0 (no pool)",
+			},
+			"max_active_leases": {
+				Type:        framework.TypeInt,
+				Description: "The maximum number of outstanding leases of the role. Default: 0 (unlimited)",
+			},
+			"max_active_leases_per_entity": {
+				Type:        framework.TypeInt,
+				Description: "The maximum number of outstanding leases of the role per Vault entity. Default: 0 (unlimited)",
+			},
+			"issue_rate": {
+				Type:        framework.TypeInt,
+				Description: "The maximum number of tokens issued by the role per minute. Default: 0 (unlimited)",
+			},
+			"description": {
+				Type:        framework.TypeString,
+				Description: "The description of the role.",
+			},
+			"owner": {
+				Type:        framework.TypeString,
+				Description: "The owner of the role, e.g. team or person responsible for it.",
+			},
+			"tags": {
+				Type:        framework.TypeKVPairs,
+				Description: "The key/value tags of the role, e.g. tags=team=ci,env=prod.",
+			},
+			"template": {
+				Type:        framework.TypeLowerCaseString,
+				Description: "The name of the role template from which the ttl, max_ttl, scopes and restrictions not set in the role are taken.",
+			},
+			"wrap_ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "The maximum TTL of the response wrapping required to read the credentials. Reading the credentials without wrapping or with a longer wrap TTL is rejected, unless delivery is wrapped. Default: 0 (not required)",
+			},
+			"delivery": {
+				Type:        framework.TypeString,
+				Description: fmt.Sprintf("How the token is delivered: %s returns it in the response, %s wraps the response with wrap_ttl, so the token can be read only once by unwrapping. Default: %s", roleDeliveryDirect, roleDeliveryWrapped, roleDeliveryDirect),
+				Default:     roleDeliveryDirect,
+			},
+			"bind_to_client_ip": {
+				Type:        framework.TypeBool,
+				Description: "Restricts every token to the address of the client reading the credentials, which must be within ip_restrictions if set. Cannot be used with pool_size.",
+			},
+			"client_ip_prefix": {
+				Type:        framework.TypeInt,
+				Description: "The prefix length of the range around the IPv4 client address the token is restricted to. Default: 32 (the address only)",
+			},
+			"client_ipv6_prefix": {
+				Type:        framework.TypeInt,
+				Description: "The prefix length of the range around the IPv6 client address the token is restricted to. Default: 128 (the address only)",
+			},
+			"max_versions": {
+				Type:        framework.TypeInt,
+				Description: "The number of role versions kept in the history. Default: 10",
+				Default:     roleDefaultMaxVersions,
+			},
 		},
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.ReadOperation: &framework.PathOperation{
 				Callback: b.pathRoleRead,
 			},
 			logical.CreateOperation: &framework.PathOperation{
-				Callback: b.pathRoleWrite,
+				Callback:                    b.pathRoleWrite,
+				ForwardPerformanceSecondary: true,
+				ForwardPerformanceStandby:   true,
 			},
 			logical.UpdateOperation: &framework.PathOperation{
-				Callback: b.pathRoleWrite,
+				Callback:                    b.pathRoleWrite,
+				ForwardPerformanceSecondary: true,
+				ForwardPerformanceStandby:   true,
 			},
 			logical.DeleteOperation: &framework.PathOperation{
-				Callback: b.pathRoleDelete,
+				Callback:                    b.pathRoleDelete,
+				ForwardPerformanceSecondary: true,
+				ForwardPerformanceStandby:   true,
 			},
 		},
 		ExistenceCheck:  b.pathRoleExistenceCheck,
@@ -74,6 +161,7 @@ func saveRole(ctx context.Context, s logical.Storage, c *roleEntry, name string)
 	sort.Strings(c.Scopes)
 	sort.Strings(c.IpRestrictions)
 	sort.Strings(c.WorkspaceRestrictions)
+	c.SchemaVersion = roleSchemaVersion
 	entry, err := logical.StorageEntryJSON(fmt.Sprintf("%s/%s", rolesStoragePath, name), c)
 	if err != nil {
 		return err
@@ -81,6 +169,43 @@ func saveRole(ctx context.Context, s logical.Storage, c *roleEntry, name string)
 	return s.Put(ctx, entry)
 }
 
+func (r *roleEntry) validate() error {
+	if r.MaxTTL != 0 && r.Ttl > r.MaxTTL {
+		return fmt.Errorf("ttl cannot be greater than max_ttl")
+	}
+	if r.PoolSize < 0 {
+		return fmt.Errorf("pool_size cannot be negative")
+	}
+	if r.MaxActiveLeases < 0 || r.MaxActiveLeasesPerEntity < 0 || r.IssueRate < 0 {
+		return fmt.Errorf("max_active_leases, max_active_leases_per_entity and issue_rate cannot be negative")
+	}
+	if r.MaxVersions < 1 {
+		return fmt.Errorf("max_versions must be at least 1")
+	}
+	if r.BindToClientIp && r.PoolSize > 0 {
+		return fmt.Errorf("bind_to_client_ip cannot be used with pool_size")
+	}
+	if r.ClientIpPrefix < 0 || r.ClientIpPrefix > 32 {
+		return fmt.Errorf("client_ip_prefix must be between 0 and 32")
+	}
+	if r.ClientIpv6Prefix < 0 || r.ClientIpv6Prefix > 128 {
+		return fmt.Errorf("client_ipv6_prefix must be between 0 and 128")
+	}
+	if r.WrapTTL < 0 {
+		return fmt.Errorf("wrap_ttl cannot be negative")
+	}
+	switch r.Delivery {
+	case roleDeliveryDirect:
+	case roleDeliveryWrapped:
+		if r.WrapTTL == 0 {
+			return fmt.Errorf("wrap_ttl must be set with delivery %s", roleDeliveryWrapped)
+		}
+	default:
+		return fmt.Errorf("delivery must be %s or %s", roleDeliveryDirect, roleDeliveryWrapped)
+	}
+	return nil
+}
+
 func getRole(ctx context.Context, name string, s logical.Storage) (*roleEntry, error) {
 	entry, err := s.Get(ctx, fmt.Sprintf("%s/%s", rolesStoragePath, name))
 	if err != nil {
@@ -90,7 +215,7 @@ func getRole(ctx context.Context, name string, s logical.Storage) (*roleEntry, e
 		return nil, nil
 	}
 	role := new(roleEntry)
-	if err := entry.DecodeJSON(role); err != nil {
+	if _, err := decodeEntry(entry, roleMigrations, role); err != nil {
 		return nil, err
 	}
 	return role, nil
@@ -108,7 +233,21 @@ func (b *buddySecretBackend) pathRoleExistenceCheck(ctx context.Context, req *lo
 func (b *buddySecretBackend) pathRoleDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	name := d.Get("name").(string)
 	err := req.Storage.Delete(ctx, fmt.Sprintf("%s/%s", rolesStoragePath, name))
-	return nil, err
+	if err != nil {
+		return nil, err
+	}
+	err = deleteRoleVersions(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	// a role created later with the same name starts without the usage of the deleted one
+	err = b.deleteRoleUsage(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	// drains the pool of the deleted role
+	b.refillPoolAsync(req.Storage, name)
+	return nil, nil
 }
 
 func (b *buddySecretBackend) pathRoleRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
@@ -122,13 +261,45 @@ func (b *buddySecretBackend) pathRoleRead(ctx context.Context, req *logical.Requ
 	}
 	resp := &logical.Response{
 		Data: map[string]interface{}{
-			"ttl":                    role.Ttl.Seconds(),
-			"max_ttl":                role.MaxTTL.Seconds(),
-			"scopes":                 role.Scopes,
-			"ip_restrictions":        role.IpRestrictions,
-			"workspace_restrictions": role.WorkspaceRestrictions,
+			"ttl":                          role.Ttl.Seconds(),
+			"max_ttl":                      role.MaxTTL.Seconds(),
+			"scopes":                       role.Scopes,
+			"ip_restrictions":              role.IpRestrictions,
+			"workspace_restrictions":       role.WorkspaceRestrictions,
+			"pool_size":                    role.PoolSize,
+			"max_active_leases":            role.MaxActiveLeases,
+			"max_active_leases_per_entity": role.MaxActiveLeasesPerEntity,
+			"issue_rate":                   role.IssueRate,
+			"description":                  role.Description,
+			"owner":                        role.Owner,
+			"tags":                         role.Tags,
+			"created_at":                   role.CreatedAt,
+			"updated_at":                   role.UpdatedAt,
+			"updated_by":                   role.UpdatedBy,
+			"version":                      role.Version,
+			"max_versions":                 role.MaxVersions,
+			"template":                     role.Template,
+			"wrap_ttl":                     role.WrapTTL.Seconds(),
+			"delivery":                     role.Delivery,
+			"bind_to_client_ip":            role.BindToClientIp,
+			"client_ip_prefix":             role.ClientIpPrefix,
+			"client_ipv6_prefix":           role.ClientIpv6Prefix,
 		},
 	}
+	if role.Template != "" || usesScopeSets(role.Scopes) {
+		effective, err := resolveRole(ctx, req.Storage, role)
+		if err != nil {
+			resp.AddWarning(fmt.Sprintf("unable to resolve the role: %s", err))
+		} else {
+			resp.Data["effective"] = map[string]interface{}{
+				"ttl":                    effective.Ttl.Seconds(),
+				"max_ttl":                effective.MaxTTL.Seconds(),
+				"scopes":                 effective.Scopes,
+				"ip_restrictions":        effective.IpRestrictions,
+				"workspace_restrictions": effective.WorkspaceRestrictions,
+			}
+		}
+	}
 	return resp, nil
 }
 
@@ -144,6 +315,7 @@ func (b *buddySecretBackend) pathRoleWrite(ctx context.Context, req *logical.Req
 		}
 		role = &roleEntry{}
 	}
+	previousPoolSize := role.PoolSize
 	if ttl, ok := d.GetOk("ttl"); ok {
 		role.Ttl = time.Duration(ttl.(int)) * time.Second
 	} else if req.Operation == logical.CreateOperation {
@@ -154,11 +326,9 @@ func (b *buddySecretBackend) pathRoleWrite(ctx context.Context, req *logical.Req
 	} else if req.Operation == logical.CreateOperation {
 		role.MaxTTL = time.Duration(d.Get("max_ttl").(int)) * time.Second
 	}
-	if role.MaxTTL != 0 && role.Ttl > role.MaxTTL {
-		return logical.ErrorResponse("ttl cannot be greater than max_ttl"), nil
-	}
+	storedScopes := role.Scopes
 	if scopes, ok := d.GetOk("scopes"); ok {
-		role.Scopes = scopes.([]string)
+		role.Scopes = normalizeScopes(scopes.([]string))
 	}
 	if ipRestrictions, ok := d.GetOk("ip_restrictions"); ok {
 		role.IpRestrictions = ipRestrictions.([]string)
@@ -166,6 +336,78 @@ func (b *buddySecretBackend) pathRoleWrite(ctx context.Context, req *logical.Req
 	if workspaceRestrictions, ok := d.GetOk("workspace_restrictions"); ok {
 		role.WorkspaceRestrictions = workspaceRestrictions.([]string)
 	}
+	if poolSize, ok := d.GetOk("pool_size"); ok {
+		role.PoolSize = poolSize.(int)
+	}
+	if maxActiveLeases, ok := d.GetOk("max_active_leases"); ok {
+		role.MaxActiveLeases = maxActiveLeases.(int)
+	}
+	if maxActiveLeasesPerEntity, ok := d.GetOk("max_active_leases_per_entity"); ok {
+		role.MaxActiveLeasesPerEntity = maxActiveLeasesPerEntity.(int)
+	}
+	if issueRate, ok := d.GetOk("issue_rate"); ok {
+		role.IssueRate = issueRate.(int)
+	}
+	if description, ok := d.GetOk("description"); ok {
+		role.Description = description.(string)
+	}
+	if owner, ok := d.GetOk("owner"); ok {
+		role.Owner = owner.(string)
+	}
+	if tags, ok := d.GetOk("tags"); ok {
+		role.Tags = tags.(map[string]string)
+	}
+	if role.Tags == nil {
+		role.Tags = map[string]string{}
+	}
+	if maxVersions, ok := d.GetOk("max_versions"); ok {
+		role.MaxVersions = maxVersions.(int)
+	} else if req.Operation == logical.CreateOperation || role.MaxVersions == 0 {
+		// roles saved before versioning get the default
+		role.MaxVersions = d.Get("max_versions").(int)
+	}
+	if template, ok := d.GetOk("template"); ok {
+		role.Template = template.(string)
+	}
+	if bindToClientIp, ok := d.GetOk("bind_to_client_ip"); ok {
+		role.BindToClientIp = bindToClientIp.(bool)
+	}
+	if clientIpPrefix, ok := d.GetOk("client_ip_prefix"); ok {
+		role.ClientIpPrefix = clientIpPrefix.(int)
+	}
+	if clientIpv6Prefix, ok := d.GetOk("client_ipv6_prefix"); ok {
+		role.ClientIpv6Prefix = clientIpv6Prefix.(int)
+	}
+	if wrapTtl, ok := d.GetOk("wrap_ttl"); ok {
+		role.WrapTTL = time.Duration(wrapTtl.(int)) * time.Second
+	}
+	if delivery, ok := d.GetOk("delivery"); ok {
+		role.Delivery = delivery.(string)
+	} else if req.Operation == logical.CreateOperation {
+		role.Delivery = d.Get("delivery").(string)
+	}
+	if err := role.validate(); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err := checkTemplateChain(ctx, req.Storage, "", role.Template); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err := validateScopes(ctx, req.Storage, role.Scopes, storedScopes); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	effective, err := resolveRole(ctx, req.Storage, role)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err := b.checkPolicy(ctx, req.Storage, effective); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	now := time.Now()
+	if role.CreatedAt.IsZero() {
+		role.CreatedAt = now
+	}
+	role.UpdatedAt = now
+	role.UpdatedBy = requestAuthor(req)
 	if role.Scopes == nil {
 		role.Scopes = []string{}
 	}
@@ -175,8 +417,24 @@ func (b *buddySecretBackend) pathRoleWrite(ctx context.Context, req *logical.Req
 	if role.WorkspaceRestrictions == nil {
 		role.WorkspaceRestrictions = []string{}
 	}
-	err = saveRole(ctx, req.Storage, role, name)
-	return nil, err
+	err = saveRoleVersion(ctx, req.Storage, role, name)
+	if err != nil {
+		return nil, err
+	}
+	if role.PoolSize > 0 || previousPoolSize > 0 {
+		// refill with the new parameters, stale and surplus tokens are deleted
+		b.refillPoolAsync(req.Storage, name)
+	}
+	return nil, nil
+}
+
+// requestAuthor returns the entity which made the request, or the token display name
+// for requests without an entity (e.g. the root token)
+func requestAuthor(req *logical.Request) string {
+	if req.EntityID != "" {
+		return req.EntityID
+	}
+	return req.DisplayName
 }
 
 const roleHelpSyn = "Manage the Vault roles used to generate Buddy tokens."
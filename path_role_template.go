@@ -0,0 +1,414 @@
+package buddysecrets
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"sort"
+	"time"
+)
+
+const (
+	roleTemplatesStoragePath = "role-templates"
+)
+
+// roleTemplateEntry holds the token parameters shared by the roles, the values set
+// in the role (or in the child template) take precedence
+type roleTemplateEntry struct {
+	Ttl                   time.Duration `json:"ttl"`
+	MaxTTL                time.Duration `json:"max_ttl"`
+	Scopes                []string      `json:"scopes"`
+	IpRestrictions        []string      `json:"ip_restrictions"`
+	WorkspaceRestrictions []string      `json:"workspace_restrictions"`
+	Parent                string        `json:"parent"`
+}
+
+func pathRoleTemplate(b *buddySecretBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "role-templates/" + framework.GenericNameRegex("name"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeLowerCaseString,
+				Description: "The name of the role template",
+			},
+			"ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "The default lease time for the generated token, used by roles without ttl.",
+			},
+			"max_ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "The maximum time the generated token can be extended to, used by roles without max_ttl.",
+			},
+			"scopes": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "The list of scopes, comma-separated. Scope sets are referenced as @name. Scopes are upper-cased, scope set names lower-cased. Used by roles without scopes.",
+			},
+			"ip_restrictions": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "The list of IP addresses to which the token is restricted, comma-separated. Used by roles without ip_restrictions.",
+			},
+			"workspace_restrictions": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "The list of workspace domains to which the token is restricted, comma-separated. Used by roles without workspace_restrictions.",
+			},
+			"parent": {
+				Type:        framework.TypeLowerCaseString,
+				Description: "The name of the role template from which the values not set in this template are taken.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathRoleTemplateRead,
+			},
+			logical.CreateOperation: &framework.PathOperation{
+				Callback:                    b.pathRoleTemplateWrite,
+				ForwardPerformanceSecondary: true,
+				ForwardPerformanceStandby:   true,
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback:                    b.pathRoleTemplateWrite,
+				ForwardPerformanceSecondary: true,
+				ForwardPerformanceStandby:   true,
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback:                    b.pathRoleTemplateDelete,
+				ForwardPerformanceSecondary: true,
+				ForwardPerformanceStandby:   true,
+			},
+		},
+		ExistenceCheck:  b.pathRoleTemplateExistenceCheck,
+		HelpSynopsis:    roleTemplateHelpSyn,
+		HelpDescription: roleTemplateHelpDesc,
+	}
+}
+
+func saveRoleTemplate(ctx context.Context, s logical.Storage, c *roleTemplateEntry, name string) error {
+	sort.Strings(c.Scopes)
+	sort.Strings(c.IpRestrictions)
+	sort.Strings(c.WorkspaceRestrictions)
+	entry, err := logical.StorageEntryJSON(fmt.Sprintf("%s/%s", roleTemplatesStoragePath, name), c)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+func getRoleTemplate(ctx context.Context, name string, s logical.Storage) (*roleTemplateEntry, error) {
+	entry, err := s.Get(ctx, fmt.Sprintf("%s/%s", roleTemplatesStoragePath, name))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	template := new(roleTemplateEntry)
+	if err := entry.DecodeJSON(template); err != nil {
+		return nil, err
+	}
+	return template, nil
+}
+
+func (t *roleTemplateEntry) validate() error {
+	if t.MaxTTL != 0 && t.Ttl > t.MaxTTL {
+		return fmt.Errorf("ttl cannot be greater than max_ttl")
+	}
+	return nil
+}
+
+// roleResolver looks up the role templates and the scope sets used to resolve the role
+type roleResolver struct {
+	template func(name string) (*roleTemplateEntry, error)
+	scopeSet func(name string) (*scopeSetEntry, error)
+}
+
+// storageResolver resolves the roles against the saved templates and scope sets
+func storageResolver(ctx context.Context, s logical.Storage) *roleResolver {
+	return &roleResolver{
+		template: func(name string) (*roleTemplateEntry, error) {
+			return getRoleTemplate(ctx, name, s)
+		},
+		scopeSet: func(name string) (*scopeSetEntry, error) {
+			return getScopeSet(ctx, name, s)
+		},
+	}
+}
+
+// resolveRole returns the effective role, the values not set in the role are taken
+// from its template and then from the parents of the template. The references to
+// scope sets are expanded
+func resolveRole(ctx context.Context, s logical.Storage, role *roleEntry) (*roleEntry, error) {
+	return storageResolver(ctx, s).resolve(role)
+}
+
+func (r *roleResolver) resolve(role *roleEntry) (*roleEntry, error) {
+	effective := *role
+	seen := map[string]bool{}
+	for name := role.Template; name != ""; {
+		if seen[name] {
+			return nil, fmt.Errorf("role template '%s' inherits from itself", name)
+		}
+		seen[name] = true
+		template, err := r.template(name)
+		if err != nil {
+			return nil, err
+		}
+		if template == nil {
+			return nil, fmt.Errorf("role template '%s' does not exist", name)
+		}
+		if effective.Ttl == 0 {
+			effective.Ttl = template.Ttl
+		}
+		if effective.MaxTTL == 0 {
+			effective.MaxTTL = template.MaxTTL
+		}
+		if len(effective.Scopes) == 0 {
+			effective.Scopes = template.Scopes
+		}
+		if len(effective.IpRestrictions) == 0 {
+			effective.IpRestrictions = template.IpRestrictions
+		}
+		if len(effective.WorkspaceRestrictions) == 0 {
+			effective.WorkspaceRestrictions = template.WorkspaceRestrictions
+		}
+		name = template.Parent
+	}
+	scopes, err := r.expandScopes(effective.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	effective.Scopes = scopes
+	// the client address is checked against the ip_restrictions, which can come from the template
+	if effective.BindToClientIp {
+		for _, restriction := range effective.IpRestrictions {
+			if _, err := parseIpRange(restriction); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &effective, nil
+}
+
+// getEffectiveRole reads the role and resolves its template
+func getEffectiveRole(ctx context.Context, name string, s logical.Storage) (*roleEntry, error) {
+	role, err := getRole(ctx, name, s)
+	if err != nil || role == nil {
+		return role, err
+	}
+	return resolveRole(ctx, s, role)
+}
+
+// checkTemplateRoles resolves the roles using the template, directly or through its child templates,
+// with the written template in place and checks them against the mount policy, as if they were written
+func (b *buddySecretBackend) checkTemplateRoles(ctx context.Context, s logical.Storage, name string, template *roleTemplateEntry) error {
+	policy, err := getPolicy(ctx, s)
+	if err != nil {
+		return err
+	}
+	resolver := storageResolver(ctx, s)
+	stored := resolver.template
+	resolver.template = func(templateName string) (*roleTemplateEntry, error) {
+		if templateName == name {
+			return template, nil
+		}
+		return stored(templateName)
+	}
+	roles, err := s.List(ctx, rolesStoragePath+"/")
+	if err != nil {
+		return err
+	}
+	for _, roleName := range roles {
+		role, err := getRole(ctx, roleName, s)
+		if err != nil {
+			return err
+		}
+		if role == nil {
+			continue
+		}
+		uses, err := resolver.inherits(role, name)
+		if err != nil {
+			return err
+		}
+		if !uses {
+			continue
+		}
+		effective, err := resolver.resolve(role)
+		if err != nil {
+			return fmt.Errorf("role '%s': %s", roleName, err)
+		}
+		if err := policy.check(effective, b.System()); err != nil {
+			return fmt.Errorf("role '%s': %s", roleName, err)
+		}
+	}
+	return nil
+}
+
+// inherits reports whether the role takes its values from the template, directly or through the parents
+func (r *roleResolver) inherits(role *roleEntry, name string) (bool, error) {
+	seen := map[string]bool{}
+	for current := role.Template; current != "" && !seen[current]; {
+		if current == name {
+			return true, nil
+		}
+		seen[current] = true
+		template, err := r.template(current)
+		if err != nil {
+			return false, err
+		}
+		if template == nil {
+			return false, nil
+		}
+		current = template.Parent
+	}
+	return false, nil
+}
+
+// checkTemplateChain verifies that the template exists and that following its parents
+// does not lead back to the template being written
+func checkTemplateChain(ctx context.Context, s logical.Storage, name string, parent string) error {
+	seen := map[string]bool{name: true}
+	for parent != "" {
+		if seen[parent] {
+			return fmt.Errorf("role template '%s' would inherit from itself", name)
+		}
+		seen[parent] = true
+		template, err := getRoleTemplate(ctx, parent, s)
+		if err != nil {
+			return err
+		}
+		if template == nil {
+			return fmt.Errorf("role template '%s' does not exist", parent)
+		}
+		parent = template.Parent
+	}
+	return nil
+}
+
+func (b *buddySecretBackend) pathRoleTemplateExistenceCheck(ctx context.Context, req *logical.Request, d *framework.FieldData) (bool, error) {
+	name := d.Get("name").(string)
+	template, err := getRoleTemplate(ctx, name, req.Storage)
+	if err != nil {
+		return false, err
+	}
+	return template != nil, nil
+}
+
+func (b *buddySecretBackend) pathRoleTemplateDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	roles, err := req.Storage.List(ctx, rolesStoragePath+"/")
+	if err != nil {
+		return nil, err
+	}
+	for _, roleName := range roles {
+		role, err := getRole(ctx, roleName, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if role != nil && role.Template == name {
+			return logical.ErrorResponse("role template '%s' is used by role '%s'", name, roleName), nil
+		}
+	}
+	templates, err := req.Storage.List(ctx, roleTemplatesStoragePath+"/")
+	if err != nil {
+		return nil, err
+	}
+	for _, templateName := range templates {
+		template, err := getRoleTemplate(ctx, templateName, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if template != nil && template.Parent == name {
+			return logical.ErrorResponse("role template '%s' is the parent of role template '%s'", name, templateName), nil
+		}
+	}
+	err = req.Storage.Delete(ctx, fmt.Sprintf("%s/%s", roleTemplatesStoragePath, name))
+	return nil, err
+}
+
+func (b *buddySecretBackend) pathRoleTemplateRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	template, err := getRoleTemplate(ctx, name, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if template == nil {
+		return nil, nil
+	}
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"ttl":                    template.Ttl.Seconds(),
+			"max_ttl":                template.MaxTTL.Seconds(),
+			"scopes":                 template.Scopes,
+			"ip_restrictions":        template.IpRestrictions,
+			"workspace_restrictions": template.WorkspaceRestrictions,
+			"parent":                 template.Parent,
+		},
+	}
+	return resp, nil
+}
+
+func (b *buddySecretBackend) pathRoleTemplateWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	template, err := getRoleTemplate(ctx, name, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if template == nil {
+		if req.Operation == logical.UpdateOperation {
+			return logical.ErrorResponse("role template not found during update operation"), nil
+		}
+		template = &roleTemplateEntry{}
+	}
+	if ttl, ok := d.GetOk("ttl"); ok {
+		template.Ttl = time.Duration(ttl.(int)) * time.Second
+	}
+	if maxTtl, ok := d.GetOk("max_ttl"); ok {
+		template.MaxTTL = time.Duration(maxTtl.(int)) * time.Second
+	}
+	storedScopes := template.Scopes
+	if scopes, ok := d.GetOk("scopes"); ok {
+		template.Scopes = normalizeScopes(scopes.([]string))
+	}
+	if ipRestrictions, ok := d.GetOk("ip_restrictions"); ok {
+		template.IpRestrictions = ipRestrictions.([]string)
+	}
+	if workspaceRestrictions, ok := d.GetOk("workspace_restrictions"); ok {
+		template.WorkspaceRestrictions = workspaceRestrictions.([]string)
+	}
+	if parent, ok := d.GetOk("parent"); ok {
+		template.Parent = parent.(string)
+	}
+	if err := template.validate(); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err := validateScopes(ctx, req.Storage, template.Scopes, storedScopes); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err := checkTemplateChain(ctx, req.Storage, name, template.Parent); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err := b.checkTemplateRoles(ctx, req.Storage, name, template); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if template.Scopes == nil {
+		template.Scopes = []string{}
+	}
+	if template.IpRestrictions == nil {
+		template.IpRestrictions = []string{}
+	}
+	if template.WorkspaceRestrictions == nil {
+		template.WorkspaceRestrictions = []string{}
+	}
+	err = saveRoleTemplate(ctx, req.Storage, template, name)
+	return nil, err
+}
+
+const roleTemplateHelpSyn = "Manage the templates shared by the Vault token roles."
+
+const roleTemplateHelpDesc = `
+This path allows you to read and write role templates. A role with the
+template field takes the ttl, max_ttl, scopes and restrictions it does not
+set itself from the template, and the template from its parent. The values
+are resolved when credentials are requested, so changes of the template
+apply to every role using it.
+`
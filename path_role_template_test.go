@@ -0,0 +1,61 @@
+package buddysecrets
+
+import (
+	"github.com/hashicorp/vault/sdk/logical"
+	"testing"
+)
+
+func TestRoleTemplateWriteChecksDependentRoles(t *testing.T) {
+	b, s := getTestBackend(t, 0)
+	testOk(t, b, s, logical.CreateOperation, "role-templates/base", map[string]interface{}{
+		"scopes": "WORKSPACE",
+	})
+	testOk(t, b, s, logical.CreateOperation, "role-templates/child", map[string]interface{}{
+		"parent": "base",
+	})
+	testOk(t, b, s, logical.CreateOperation, "role-templates/bound", map[string]interface{}{
+		"ip_restrictions": "10.0.0.0/8",
+	})
+	testOk(t, b, s, logical.CreateOperation, "roles/r1", map[string]interface{}{
+		"template": "child",
+	})
+	testOk(t, b, s, logical.CreateOperation, "roles/r2", map[string]interface{}{
+		"scopes":            "WORKSPACE",
+		"template":          "bound",
+		"bind_to_client_ip": true,
+	})
+	testOk(t, b, s, logical.UpdateOperation, "config/policy", map[string]interface{}{
+		"denied_scopes": "EXECUTION_RUN",
+	})
+
+	tests := []struct {
+		name     string
+		template string
+		data     map[string]interface{}
+	}{
+		{
+			name:     "role through the child template violates the policy",
+			template: "base",
+			data:     map[string]interface{}{"scopes": "EXECUTION_RUN"},
+		},
+		{
+			name:     "role bound to the client gets invalid ip_restrictions",
+			template: "bound",
+			data:     map[string]interface{}{"ip_restrictions": "not an address"},
+		},
+	}
+	for _, tt := range tests {
+		resp := testRequest(t, b, s, logical.UpdateOperation, "role-templates/"+tt.template, tt.data)
+		if !resp.IsError() {
+			t.Errorf("%s: template must be rejected", tt.name)
+		}
+	}
+
+	// the roles not using the template are not affected
+	testOk(t, b, s, logical.CreateOperation, "role-templates/unused", map[string]interface{}{
+		"scopes": "EXECUTION_RUN",
+	})
+	testOk(t, b, s, logical.UpdateOperation, "role-templates/base", map[string]interface{}{
+		"scopes": "WORKSPACE,PROJECT_DELETE",
+	})
+}
@@ -0,0 +1,31 @@
+package buddysecrets
+
+import (
+	"context"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathRoleTemplates(b *buddySecretBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "role-templates/?",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ListOperation: &framework.PathOperation{
+				Callback: b.pathRoleTemplatesList,
+			},
+		},
+		HelpSynopsis:    roleTemplatesHelpSyn,
+		HelpDescription: roleTemplatesHelpDesc,
+	}
+}
+
+func (b *buddySecretBackend) pathRoleTemplatesList(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	templates, err := req.Storage.List(ctx, roleTemplatesStoragePath+"/")
+	if err != nil {
+		return nil, err
+	}
+	return logical.ListResponse(templates), nil
+}
+
+const roleTemplatesHelpSyn = "List existing role templates."
+const roleTemplatesHelpDesc = "List existing role templates by name."
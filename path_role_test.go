@@ -0,0 +1,41 @@
+package buddysecrets
+
+import (
+	"context"
+	"github.com/hashicorp/vault/sdk/logical"
+	"slices"
+	"testing"
+)
+
+func TestRoleWriteKeepsStoredScopes(t *testing.T) {
+	b, s := getTestBackend(t, 0)
+	ctx := context.Background()
+	testOk(t, b, s, logical.CreateOperation, "roles/r1", map[string]interface{}{
+		"scopes": "workspace",
+	})
+	role, err := getRole(ctx, "r1", s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(role.Scopes, []string{"WORKSPACE"}) {
+		t.Fatalf("scopes must be upper-cased, got %v", role.Scopes)
+	}
+	// a scope Buddy added after the list of the known scopes
+	role.Scopes = append(role.Scopes, "NEW_SCOPE")
+	if err := saveRole(ctx, s, role, "r1"); err != nil {
+		t.Fatal(err)
+	}
+
+	testOk(t, b, s, logical.UpdateOperation, "roles/r1", map[string]interface{}{
+		"description": "updated",
+	})
+	testOk(t, b, s, logical.UpdateOperation, "roles/r1", map[string]interface{}{
+		"scopes": "WORKSPACE,NEW_SCOPE,EXECUTION_RUN",
+	})
+	resp := testRequest(t, b, s, logical.UpdateOperation, "roles/r1", map[string]interface{}{
+		"scopes": "WORKSPACE,OTHER_SCOPE",
+	})
+	if !resp.IsError() {
+		t.Fatal("unknown scope added in the write must be rejected")
+	}
+}
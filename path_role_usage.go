@@ -0,0 +1,180 @@
+package buddysecrets
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/time/rate"
+	"time"
+)
+
+const (
+	usageStoragePath = "usage"
+)
+
+// roleUsage is the engine-tracked number of outstanding leases of the role
+type roleUsage struct {
+	ActiveLeases int            `json:"active_leases"`
+	EntityLeases map[string]int `json:"entity_leases"`
+}
+
+func pathRoleUsage(b *buddySecretBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex("name") + "/usage",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeLowerCaseString,
+				Description: "The name of the role",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathRoleUsageRead,
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback: b.pathRoleUsageDelete,
+				// the counters are kept in the local storage of the cluster
+				ForwardPerformanceStandby: true,
+			},
+		},
+		HelpSynopsis:    roleUsageHelpSyn,
+		HelpDescription: roleUsageHelpDesc,
+	}
+}
+
+func getRoleUsage(ctx context.Context, name string, s logical.Storage) (*roleUsage, error) {
+	entry, err := s.Get(ctx, fmt.Sprintf("%s/%s", usageStoragePath, name))
+	if err != nil {
+		return nil, err
+	}
+	usage := &roleUsage{
+		EntityLeases: map[string]int{},
+	}
+	if entry == nil {
+		return usage, nil
+	}
+	if err := entry.DecodeJSON(usage); err != nil {
+		return nil, err
+	}
+	if usage.EntityLeases == nil {
+		usage.EntityLeases = map[string]int{}
+	}
+	return usage, nil
+}
+
+// deleteRoleUsage removes the lease counters and the issue rate limiter of the role
+func (b *buddySecretBackend) deleteRoleUsage(ctx context.Context, s logical.Storage, name string) error {
+	b.usageLock.Lock()
+	defer b.usageLock.Unlock()
+	delete(b.issueLimiters, name)
+	return s.Delete(ctx, fmt.Sprintf("%s/%s", usageStoragePath, name))
+}
+
+func saveRoleUsage(ctx context.Context, s logical.Storage, usage *roleUsage, name string) error {
+	entry, err := logical.StorageEntryJSON(fmt.Sprintf("%s/%s", usageStoragePath, name), usage)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+// issueLimiter returns the token bucket of the role, recreated when issue_rate changes
+func (b *buddySecretBackend) issueLimiter(roleName string, issueRate int) *rate.Limiter {
+	b.usageLock.Lock()
+	defer b.usageLock.Unlock()
+	limit := rate.Every(time.Minute / time.Duration(issueRate))
+	limiter, ok := b.issueLimiters[roleName]
+	if !ok || limiter.Limit() != limit || limiter.Burst() != issueRate {
+		limiter = rate.NewLimiter(limit, issueRate)
+		b.issueLimiters[roleName] = limiter
+	}
+	return limiter
+}
+
+// reserveLease checks the limits of the role and counts the new lease. Leases are counted
+// even without limits, so limits added later are enforced against the real usage.
+// It returns an error response when a limit is reached
+func (b *buddySecretBackend) reserveLease(ctx context.Context, s logical.Storage, roleName string, role *roleEntry, entityId string) (*logical.Response, error) {
+	if role.IssueRate > 0 && !b.issueLimiter(roleName, role.IssueRate).Allow() {
+		return logical.ErrorResponse("role '%s' reached the issue rate of %d tokens per minute", roleName, role.IssueRate), nil
+	}
+	b.usageLock.Lock()
+	defer b.usageLock.Unlock()
+	usage, err := getRoleUsage(ctx, roleName, s)
+	if err != nil {
+		return nil, err
+	}
+	if role.MaxActiveLeases > 0 && usage.ActiveLeases >= role.MaxActiveLeases {
+		return logical.ErrorResponse("role '%s' reached the limit of %d active leases", roleName, role.MaxActiveLeases), nil
+	}
+	if role.MaxActiveLeasesPerEntity > 0 && usage.EntityLeases[entityId] >= role.MaxActiveLeasesPerEntity {
+		return logical.ErrorResponse("role '%s' reached the limit of %d active leases per entity", roleName, role.MaxActiveLeasesPerEntity), nil
+	}
+	usage.ActiveLeases++
+	usage.EntityLeases[entityId]++
+	return nil, saveRoleUsage(ctx, s, usage, roleName)
+}
+
+// releaseLease uncounts the lease of the role
+func (b *buddySecretBackend) releaseLease(ctx context.Context, s logical.Storage, roleName string, entityId string) error {
+	b.usageLock.Lock()
+	defer b.usageLock.Unlock()
+	usage, err := getRoleUsage(ctx, roleName, s)
+	if err != nil {
+		return err
+	}
+	if usage.ActiveLeases > 0 {
+		usage.ActiveLeases--
+	}
+	if usage.EntityLeases[entityId] > 1 {
+		usage.EntityLeases[entityId]--
+	} else {
+		delete(usage.EntityLeases, entityId)
+	}
+	return saveRoleUsage(ctx, s, usage, roleName)
+}
+
+func (b *buddySecretBackend) pathRoleUsageRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	role, err := getRole(ctx, name, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, nil
+	}
+	b.usageLock.Lock()
+	usage, err := getRoleUsage(ctx, name, req.Storage)
+	b.usageLock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"active_leases":                usage.ActiveLeases,
+			"entity_leases":                usage.EntityLeases,
+			"max_active_leases":            role.MaxActiveLeases,
+			"max_active_leases_per_entity": role.MaxActiveLeasesPerEntity,
+			"issue_rate":                   role.IssueRate,
+		},
+	}
+	if role.IssueRate > 0 {
+		resp.Data["issue_rate_available"] = int(b.issueLimiter(name, role.IssueRate).Tokens())
+	}
+	return resp, nil
+}
+
+func (b *buddySecretBackend) pathRoleUsageDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	b.requestLogger(req).Info("resetting role usage", "role", name)
+	return nil, b.deleteRoleUsage(ctx, req.Storage, name)
+}
+
+const roleUsageHelpSyn = "Read the current usage of the role limits."
+const roleUsageHelpDesc = `
+This path returns the number of active leases of the role, in total and per
+entity, together with the limits configured in the role.
+Deleting it resets the counters and the issue rate of the role on the cluster,
+e.g. when leases were force-revoked without releasing their count.
+`
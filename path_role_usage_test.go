@@ -0,0 +1,102 @@
+package buddysecrets
+
+import (
+	"context"
+	"github.com/hashicorp/vault/sdk/logical"
+	"testing"
+)
+
+// testCreds reads the credentials of the role as the entity
+func testCreds(t *testing.T, b *buddySecretBackend, s logical.Storage, role string, entityId string) *logical.Response {
+	t.Helper()
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/" + role,
+		Storage:   s,
+		EntityID:  entityId,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestRoleLeaseLimits(t *testing.T) {
+	f := newFakeBuddy(t)
+	b, s := getTestBackend(t, 0)
+	testConfigure(t, b, s, f)
+	testOk(t, b, s, logical.CreateOperation, "roles/r1", map[string]interface{}{
+		"scopes":                       "WORKSPACE",
+		"max_active_leases":            2,
+		"max_active_leases_per_entity": 1,
+	})
+
+	first := testCreds(t, b, s, "r1", "e1")
+	if first.IsError() {
+		t.Fatal(first.Error())
+	}
+	if resp := testCreds(t, b, s, "r1", "e1"); !resp.IsError() {
+		t.Fatal("entity must not exceed max_active_leases_per_entity")
+	}
+	if resp := testCreds(t, b, s, "r1", "e2"); resp.IsError() {
+		t.Fatal(resp.Error())
+	}
+	if resp := testCreds(t, b, s, "r1", "e3"); !resp.IsError() {
+		t.Fatal("role must not exceed max_active_leases")
+	}
+	resp := testOk(t, b, s, logical.ReadOperation, "roles/r1/usage", nil)
+	if resp.Data["active_leases"] != 2 {
+		t.Fatalf("expected 2 active leases, got %v", resp.Data["active_leases"])
+	}
+
+	// revoking the lease releases its count
+	if err := testRevoke(t, b, s, first.Secret); err != nil {
+		t.Fatal(err)
+	}
+	if resp := testCreds(t, b, s, "r1", "e1"); resp.IsError() {
+		t.Fatal(resp.Error())
+	}
+
+	// leases force-revoked without releasing their count are reset by deleting the usage
+	testOk(t, b, s, logical.DeleteOperation, "roles/r1/usage", nil)
+	resp = testOk(t, b, s, logical.ReadOperation, "roles/r1/usage", nil)
+	if resp.Data["active_leases"] != 0 {
+		t.Fatalf("expected the reset usage, got %v", resp.Data["active_leases"])
+	}
+	if resp := testCreds(t, b, s, "r1", "e3"); resp.IsError() {
+		t.Fatal(resp.Error())
+	}
+}
+
+func TestRoleIssueRate(t *testing.T) {
+	f := newFakeBuddy(t)
+	b, s := getTestBackend(t, 0)
+	testConfigure(t, b, s, f)
+	testOk(t, b, s, logical.CreateOperation, "roles/r1", map[string]interface{}{
+		"scopes":     "WORKSPACE",
+		"issue_rate": 1,
+	})
+	testOk(t, b, s, logical.ReadOperation, "creds/r1", nil)
+	if resp := testRequest(t, b, s, logical.ReadOperation, "creds/r1", nil); !resp.IsError() {
+		t.Fatal("role must not exceed issue_rate")
+	}
+	testOk(t, b, s, logical.DeleteOperation, "roles/r1/usage", nil)
+	testOk(t, b, s, logical.ReadOperation, "creds/r1", nil)
+}
+
+func TestRoleDeleteDropsUsage(t *testing.T) {
+	f := newFakeBuddy(t)
+	b, s := getTestBackend(t, 0)
+	testConfigure(t, b, s, f)
+	data := map[string]interface{}{
+		"scopes":            "WORKSPACE",
+		"max_active_leases": 1,
+	}
+	testOk(t, b, s, logical.CreateOperation, "roles/r1", data)
+	testOk(t, b, s, logical.ReadOperation, "creds/r1", nil)
+	testOk(t, b, s, logical.DeleteOperation, "roles/r1", nil)
+
+	// the role created again with the same name starts without the leases of the deleted one
+	testOk(t, b, s, logical.CreateOperation, "roles/r1", data)
+	testOk(t, b, s, logical.ReadOperation, "creds/r1", nil)
+}
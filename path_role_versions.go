@@ -0,0 +1,357 @@
+package buddysecrets
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	roleVersionsStoragePath = "role-versions"
+	roleDefaultMaxVersions  = 10
+)
+
+// roleVersion is the copy of the role saved on every write
+type roleVersion struct {
+	Version   int        `json:"version"`
+	Role      *roleEntry `json:"role"`
+	CreatedAt time.Time  `json:"created_at"`
+	CreatedBy string     `json:"created_by"`
+}
+
+func pathRoleVersions(b *buddySecretBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "roles/" + framework.GenericNameRegex("name") + "/versions/?",
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "The name of the role",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.pathRoleVersionsList,
+				},
+			},
+			HelpSynopsis:    roleVersionsHelpSyn,
+			HelpDescription: roleVersionsHelpDesc,
+		},
+		{
+			Pattern: "roles/" + framework.GenericNameRegex("name") + "/versions/(?P<version>\\d+)",
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "The name of the role",
+				},
+				"version": {
+					Type:        framework.TypeInt,
+					Description: "The version of the role",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathRoleVersionRead,
+				},
+			},
+			HelpSynopsis:    roleVersionsHelpSyn,
+			HelpDescription: roleVersionsHelpDesc,
+		},
+		{
+			Pattern: "roles/" + framework.GenericNameRegex("name") + "/rollback",
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "The name of the role",
+				},
+				"version": {
+					Type:        framework.TypeInt,
+					Description: "The version of the role to restore",
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback:                    b.pathRoleRollback,
+					ForwardPerformanceSecondary: true,
+					ForwardPerformanceStandby:   true,
+				},
+			},
+			HelpSynopsis:    roleRollbackHelpSyn,
+			HelpDescription: roleRollbackHelpDesc,
+		},
+	}
+}
+
+func roleVersionPath(name string, version int) string {
+	return fmt.Sprintf("%s/%s/%d", roleVersionsStoragePath, name, version)
+}
+
+// saveRoleVersion bumps the version of the role, saves it together with its copy in the
+// history and deletes the versions above max_versions
+func saveRoleVersion(ctx context.Context, s logical.Storage, role *roleEntry, name string) error {
+	role.Version++
+	err := saveRole(ctx, s, role, name)
+	if err != nil {
+		return err
+	}
+	entry, err := logical.StorageEntryJSON(roleVersionPath(name, role.Version), &roleVersion{
+		Version:   role.Version,
+		Role:      role,
+		CreatedAt: role.UpdatedAt,
+		CreatedBy: role.UpdatedBy,
+	})
+	if err != nil {
+		return err
+	}
+	err = s.Put(ctx, entry)
+	if err != nil {
+		return err
+	}
+	versions, err := listRoleVersions(ctx, s, name)
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		if version <= role.Version-role.MaxVersions {
+			if err := s.Delete(ctx, roleVersionPath(name, version)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// listRoleVersions returns the version numbers in the history of the role, ascending
+func listRoleVersions(ctx context.Context, s logical.Storage, name string) ([]int, error) {
+	keys, err := s.List(ctx, fmt.Sprintf("%s/%s/", roleVersionsStoragePath, name))
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]int, 0, len(keys))
+	for _, key := range keys {
+		version, err := strconv.Atoi(key)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, version)
+	}
+	sort.Ints(versions)
+	return versions, nil
+}
+
+func getRoleVersion(ctx context.Context, s logical.Storage, name string, version int) (*roleVersion, error) {
+	entry, err := s.Get(ctx, roleVersionPath(name, version))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	v, _, err := decodeRoleVersion(entry)
+	return v, err
+}
+
+// decodeRoleVersion decodes the version, upgrading its copy of the role to the current schema version
+func decodeRoleVersion(entry *logical.StorageEntry) (*roleVersion, bool, error) {
+	raw := map[string]interface{}{}
+	if err := entry.DecodeJSON(&raw); err != nil {
+		return nil, false, err
+	}
+	migrated := false
+	if role, ok := raw["role"].(map[string]interface{}); ok {
+		var err error
+		migrated, err = migrateRaw(role, roleMigrations)
+		if err != nil {
+			return nil, false, fmt.Errorf("%s: %w", entry.Key, err)
+		}
+	}
+	v := new(roleVersion)
+	if err := remarshal(raw, v); err != nil {
+		return nil, false, err
+	}
+	return v, migrated, nil
+}
+
+func deleteRoleVersions(ctx context.Context, s logical.Storage, name string) error {
+	versions, err := listRoleVersions(ctx, s, name)
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		if err := s.Delete(ctx, roleVersionPath(name, version)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshotRoleVersions returns the raw version history of the roles, restored by restoreRoleVersions
+func snapshotRoleVersions(ctx context.Context, s logical.Storage, names []string) (map[string][]*logical.StorageEntry, error) {
+	snapshot := map[string][]*logical.StorageEntry{}
+	for _, name := range names {
+		versions, err := listRoleVersions(ctx, s, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, version := range versions {
+			entry, err := s.Get(ctx, roleVersionPath(name, version))
+			if err != nil {
+				return nil, err
+			}
+			if entry != nil {
+				snapshot[name] = append(snapshot[name], entry)
+			}
+		}
+	}
+	return snapshot, nil
+}
+
+// restoreRoleVersions replaces the version history of the roles with the snapshot
+func restoreRoleVersions(ctx context.Context, s logical.Storage, snapshot map[string][]*logical.StorageEntry) error {
+	for name, entries := range snapshot {
+		if err := deleteRoleVersions(ctx, s, name); err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := s.Put(ctx, entry); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (b *buddySecretBackend) pathRoleVersionsList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	versions, err := listRoleVersions(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(versions))
+	keyInfo := map[string]interface{}{}
+	for _, version := range versions {
+		v, err := getRoleVersion(ctx, req.Storage, name, version)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			continue
+		}
+		key := strconv.Itoa(version)
+		keys = append(keys, key)
+		keyInfo[key] = map[string]interface{}{
+			"created_at": v.CreatedAt,
+			"created_by": v.CreatedBy,
+		}
+	}
+	return logical.ListResponseWithInfo(keys, keyInfo), nil
+}
+
+func (b *buddySecretBackend) pathRoleVersionRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	v, err := getRoleVersion(ctx, req.Storage, d.Get("name").(string), d.Get("version").(int))
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, nil
+	}
+	role := v.Role
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"version":                      v.Version,
+			"created_at":                   v.CreatedAt,
+			"created_by":                   v.CreatedBy,
+			"ttl":                          role.Ttl.Seconds(),
+			"max_ttl":                      role.MaxTTL.Seconds(),
+			"scopes":                       role.Scopes,
+			"ip_restrictions":              role.IpRestrictions,
+			"workspace_restrictions":       role.WorkspaceRestrictions,
+			"pool_size":                    role.PoolSize,
+			"max_active_leases":            role.MaxActiveLeases,
+			"max_active_leases_per_entity": role.MaxActiveLeasesPerEntity,
+			"issue_rate":                   role.IssueRate,
+			"description":                  role.Description,
+			"owner":                        role.Owner,
+			"tags":                         role.Tags,
+			"template":                     role.Template,
+			"max_versions":                 role.MaxVersions,
+			"wrap_ttl":                     role.WrapTTL.Seconds(),
+			"delivery":                     role.Delivery,
+			"bind_to_client_ip":            role.BindToClientIp,
+			"client_ip_prefix":             role.ClientIpPrefix,
+			"client_ipv6_prefix":           role.ClientIpv6Prefix,
+		},
+	}, nil
+}
+
+func (b *buddySecretBackend) pathRoleRollback(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	role, err := getRole(ctx, name, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse("role '%s' does not exist", name), nil
+	}
+	version := d.Get("version").(int)
+	v, err := getRoleVersion(ctx, req.Storage, name, version)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return logical.ErrorResponse("version %d of role '%s' does not exist", version, name), nil
+	}
+	// the version is validated as a new write, the templates, scope sets and the policy could have changed since
+	if err := v.Role.validate(); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err := checkTemplateChain(ctx, req.Storage, "", v.Role.Template); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err := validateScopes(ctx, req.Storage, v.Role.Scopes, role.Scopes); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	effective, err := resolveRole(ctx, req.Storage, v.Role)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err := b.checkPolicy(ctx, req.Storage, effective); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	// the restored copy becomes the newest version, the history is kept
+	restored := v.Role
+	restored.Version = role.Version
+	restored.CreatedAt = role.CreatedAt
+	restored.UpdatedAt = time.Now()
+	restored.UpdatedBy = requestAuthor(req)
+	err = saveRoleVersion(ctx, req.Storage, restored, name)
+	if err != nil {
+		return nil, err
+	}
+	if restored.PoolSize > 0 || role.PoolSize > 0 {
+		b.refillPoolAsync(req.Storage, name)
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"version": restored.Version,
+		},
+	}, nil
+}
+
+const roleVersionsHelpSyn = "List and read the previous versions of the role."
+const roleVersionsHelpDesc = `
+Every write of the role saves a copy of it in the history, up to max_versions
+of the role. This path lists the versions with the time and the author of the
+change, and reads the role as it was in the given version.
+`
+
+const roleRollbackHelpSyn = "Restore the previous version of the role."
+const roleRollbackHelpDesc = `
+This path restores the role from the given version of the history. The
+restored role is saved as a new version, so the rollback can be reverted.
+`
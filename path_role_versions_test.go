@@ -0,0 +1,65 @@
+package buddysecrets
+
+import (
+	"github.com/hashicorp/vault/sdk/logical"
+	"testing"
+)
+
+func TestRoleVersionRead(t *testing.T) {
+	b, s := getTestBackend(t, 0)
+	testOk(t, b, s, logical.CreateOperation, "roles/r1", map[string]interface{}{
+		"scopes":             "WORKSPACE",
+		"ip_restrictions":    "10.0.0.0/8",
+		"bind_to_client_ip":  true,
+		"client_ip_prefix":   24,
+		"client_ipv6_prefix": 64,
+		"wrap_ttl":           60,
+		"delivery":           roleDeliveryWrapped,
+		"max_versions":       5,
+	})
+	resp := testOk(t, b, s, logical.ReadOperation, "roles/r1/versions/1", nil)
+	want := map[string]interface{}{
+		"wrap_ttl":           float64(60),
+		"delivery":           roleDeliveryWrapped,
+		"bind_to_client_ip":  true,
+		"client_ip_prefix":   24,
+		"client_ipv6_prefix": 64,
+		"max_versions":       5,
+	}
+	for key, value := range want {
+		if resp.Data[key] != value {
+			t.Errorf("%s: expected %v, got %v", key, value, resp.Data[key])
+		}
+	}
+}
+
+func TestRoleRollbackValidatesVersion(t *testing.T) {
+	b, s := getTestBackend(t, 0)
+	testOk(t, b, s, logical.CreateOperation, "roles/r1", map[string]interface{}{
+		"scopes": "WORKSPACE",
+	})
+	testOk(t, b, s, logical.UpdateOperation, "roles/r1", map[string]interface{}{
+		"scopes": "EXECUTION_RUN",
+	})
+	// the policy denies the scope of the first version after it was saved
+	testOk(t, b, s, logical.UpdateOperation, "config/policy", map[string]interface{}{
+		"denied_scopes": "WORKSPACE",
+	})
+	resp := testRequest(t, b, s, logical.UpdateOperation, "roles/r1/rollback", map[string]interface{}{
+		"version": 1,
+	})
+	if !resp.IsError() {
+		t.Fatal("version violating the policy must not be restored")
+	}
+
+	testOk(t, b, s, logical.UpdateOperation, "config/policy", map[string]interface{}{
+		"denied_scopes": "",
+	})
+	testOk(t, b, s, logical.UpdateOperation, "roles/r1/rollback", map[string]interface{}{
+		"version": 1,
+	})
+	resp = testOk(t, b, s, logical.ReadOperation, "roles/r1", nil)
+	if scopes := resp.Data["scopes"].([]string); len(scopes) != 1 || scopes[0] != "WORKSPACE" {
+		t.Fatalf("expected the scopes of the first version, got %v", scopes)
+	}
+}
@@ -2,13 +2,49 @@ package buddysecrets
 
 import (
 	"context"
+	"fmt"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
+	"slices"
+	"sort"
+	"strings"
 )
 
 func pathRoles(b *buddySecretBackend) *framework.Path {
 	return &framework.Path{
 		Pattern: "roles/?",
+		Fields: map[string]*framework.FieldSchema{
+			"owner": {
+				Type:        framework.TypeString,
+				Description: "List only the roles with this owner.",
+				Query:       true,
+			},
+			"tag": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "List only the roles with all of these tags, comma-separated key=value pairs.",
+				Query:       true,
+			},
+			"scope": {
+				Type:        framework.TypeString,
+				Description: "List only the roles with this scope, e.g. WORKSPACE_MANAGE.",
+				Query:       true,
+			},
+			"detailed": {
+				Type:        framework.TypeBool,
+				Description: "Return the scopes, TTLs and restrictions of every role in key_info.",
+				Query:       true,
+			},
+			"after": {
+				Type:        framework.TypeString,
+				Description: "List only the roles after this name, used for pagination.",
+				Query:       true,
+			},
+			"limit": {
+				Type:        framework.TypeInt,
+				Description: "The maximum number of roles returned. Default: 0 (unlimited)",
+				Query:       true,
+			},
+		},
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.ListOperation: &framework.PathOperation{
 				Callback: b.pathRolesList,
@@ -19,13 +55,115 @@ func pathRoles(b *buddySecretBackend) *framework.Path {
 	}
 }
 
-func (b *buddySecretBackend) pathRolesList(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+// roleFilter selects the roles by their metadata
+type roleFilter struct {
+	owner string
+	tags  map[string]string
+	scope string
+}
+
+func newRoleFilter(d *framework.FieldData) (*roleFilter, error) {
+	f := &roleFilter{
+		owner: d.Get("owner").(string),
+		tags:  map[string]string{},
+		scope: strings.ToUpper(d.Get("scope").(string)),
+	}
+	for _, tag := range d.Get("tag").([]string) {
+		key, value, ok := strings.Cut(tag, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("tag must be in the key=value format")
+		}
+		f.tags[key] = value
+	}
+	return f, nil
+}
+
+func (f *roleFilter) empty() bool {
+	return f.owner == "" && len(f.tags) == 0 && f.scope == ""
+}
+
+func (f *roleFilter) matches(role *roleEntry) bool {
+	if f.owner != "" && role.Owner != f.owner {
+		return false
+	}
+	for key, value := range f.tags {
+		if v, ok := role.Tags[key]; !ok || v != value {
+			return false
+		}
+	}
+	if f.scope != "" && !slices.Contains(role.Scopes, f.scope) {
+		return false
+	}
+	return true
+}
+
+func (b *buddySecretBackend) pathRolesList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	filter, err := newRoleFilter(d)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	detailed := d.Get("detailed").(bool)
+	after := d.Get("after").(string)
+	limit := d.Get("limit").(int)
+	if limit < 0 {
+		return logical.ErrorResponse("limit cannot be negative"), nil
+	}
 	roles, err := req.Storage.List(ctx, rolesStoragePath+"/")
 	if err != nil {
 		return nil, err
 	}
-	return logical.ListResponse(roles), nil
+	sort.Strings(roles)
+	keys := make([]string, 0, len(roles))
+	keyInfo := map[string]interface{}{}
+	for _, name := range roles {
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+		if after != "" && name <= after {
+			continue
+		}
+		if filter.empty() && !detailed {
+			keys = append(keys, name)
+			continue
+		}
+		// the scopes and restrictions taken from the templates and scope sets are listed and filtered too
+		role, err := getEffectiveRole(ctx, name, req.Storage)
+		if err != nil {
+			// the broken roles are listed as declared, the error is reported when credentials are requested
+			role, err = getRole(ctx, name, req.Storage)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if role == nil || !filter.matches(role) {
+			continue
+		}
+		keys = append(keys, name)
+		if detailed {
+			keyInfo[name] = map[string]interface{}{
+				"ttl":                    role.Ttl.Seconds(),
+				"max_ttl":                role.MaxTTL.Seconds(),
+				"scopes":                 role.Scopes,
+				"ip_restrictions":        role.IpRestrictions,
+				"workspace_restrictions": role.WorkspaceRestrictions,
+				"description":            role.Description,
+				"owner":                  role.Owner,
+			}
+		}
+	}
+	if detailed {
+		return logical.ListResponseWithInfo(keys, keyInfo), nil
+	}
+	return logical.ListResponse(keys), nil
 }
 
 const rolesHelpSyn = "List existing roles."
-const rolesHelpDesc = "List existing roles by name."
+const rolesHelpDesc = `
+List existing roles by name. The roles can be filtered by owner, tags and
+scope through the query parameters of the HTTP API, e.g.
+"LIST /v1/buddy/roles?owner=ci&tag=env=prod". The filters and key_info use the
+effective roles, with the scopes and restrictions of their templates and
+scope sets. With detailed=true the scopes, TTLs and restrictions of every role
+are returned in key_info. The list is sorted by name and paginated with after
+and limit.
+`
@@ -0,0 +1,49 @@
+package buddysecrets
+
+import (
+	"github.com/hashicorp/vault/sdk/logical"
+	"slices"
+	"testing"
+)
+
+func TestRolesListEffectiveScopes(t *testing.T) {
+	b, s := getTestBackend(t, 0)
+	testOk(t, b, s, logical.CreateOperation, "scope-sets/manage", map[string]interface{}{
+		"scopes": "WORKSPACE,EXECUTION_MANAGE",
+	})
+	testOk(t, b, s, logical.CreateOperation, "role-templates/admin", map[string]interface{}{
+		"scopes": "TOKEN_INFO",
+	})
+	testOk(t, b, s, logical.CreateOperation, "roles/declared", map[string]interface{}{
+		"scopes": "EXECUTION_MANAGE",
+	})
+	testOk(t, b, s, logical.CreateOperation, "roles/from-set", map[string]interface{}{
+		"scopes": "@manage",
+	})
+	testOk(t, b, s, logical.CreateOperation, "roles/from-template", map[string]interface{}{
+		"template": "admin",
+	})
+
+	tests := map[string][]string{
+		"EXECUTION_MANAGE": {"declared", "from-set"},
+		"TOKEN_INFO":       {"from-template"},
+		"WORKSPACE":        {"from-set"},
+	}
+	for scope, expected := range tests {
+		resp := testOk(t, b, s, logical.ListOperation, "roles/", map[string]interface{}{
+			"scope":    scope,
+			"detailed": true,
+		})
+		keys, _ := resp.Data["keys"].([]string)
+		if !slices.Equal(keys, expected) {
+			t.Fatalf("scope %s: expected %v, got %v", scope, expected, keys)
+		}
+		info := resp.Data["key_info"].(map[string]interface{})
+		for _, name := range keys {
+			scopes := info[name].(map[string]interface{})["scopes"].([]string)
+			if !slices.Contains(scopes, scope) {
+				t.Fatalf("key_info of %s must have the effective scopes, got %v", name, scopes)
+			}
+		}
+	}
+}
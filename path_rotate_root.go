@@ -3,6 +3,7 @@ package buddysecrets
 import (
 	"context"
 	"fmt"
+	"github.com/armon/go-metrics"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 	"time"
@@ -11,6 +12,12 @@ import (
 func pathRotateConfig(b *buddySecretBackend) *framework.Path {
 	return &framework.Path{
 		Pattern: "rotate-root",
+		Fields: map[string]*framework.FieldSchema{
+			"workspace": {
+				Type:        framework.TypeString,
+				Description: "The domain of the workspace whose root token is rotated. Default: the root credentials",
+			},
+		},
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.UpdateOperation: &framework.PathOperation{
 				Callback:                    b.pathRotateRoot,
@@ -23,48 +30,104 @@ func pathRotateConfig(b *buddySecretBackend) *framework.Path {
 	}
 }
 
-func (b *buddySecretBackend) rotateRootToken(ctx context.Context, sys *logical.Request) error {
+// rotateRootToken rotates the root credentials, or the root token of the workspace if set
+func (b *buddySecretBackend) rotateRootToken(ctx context.Context, sys *logical.Request, workspace string) (err error) {
+	defer func() {
+		labels := []metrics.Label{{Name: "status", Value: metricsStatus(err)}}
+		if workspace != "" {
+			labels = append(labels, metrics.Label{Name: "workspace", Value: workspace})
+		}
+		incrCounter([]string{"root", "rotate"}, labels...)
+	}()
+	// Buddy may rotate the refresh token on every exchange, so the config is read and the credentials
+	// are exchanged under the same lock as the refresh of getClient
+	b.lock.Lock()
+	defer b.lock.Unlock()
 	config, err := b.getConfig(ctx, sys.Storage)
 	if err != nil {
 		return err
 	}
-	if config == nil || config.Token == "" {
+	if config == nil || !config.hasRootCredential() {
 		return fmt.Errorf("root token not provided through config")
 	}
-	client, err := b.getNewClient(config)
+	logger := b.requestLogger(sys)
+	// the workspace root token is rotated in its own config, stored back in the root config
+	rootConfig := config
+	if workspace != "" {
+		if config.WorkspaceTokens[workspace] == nil {
+			return fmt.Errorf("root token of workspace '%s' not provided through config", workspace)
+		}
+		rootConfig = config.forWorkspace(workspace)
+		logger = logger.With("workspace", workspace)
+	} else if config.usesIdentityToken() {
+		return fmt.Errorf("root credential is exchanged for plugin identity tokens and cannot be rotated")
+	} else if config.usesOAuth() {
+		logger = logger.With("client_id", config.ClientId)
+		// exchanging the refresh token rotates it and issues a new access token
+		err = refreshAccessToken(config)
+		if err != nil {
+			logger.Error("error while refreshing oauth access token", "error", config.redact(err.Error()))
+			return err
+		}
+		if err := b.saveConfigLocked(ctx, config, sys.Storage); err != nil {
+			return err
+		}
+		logger.Info("rotated oauth refresh token", "access_token_expires_at", config.AccessTokenExpiresAt)
+		return nil
+	}
+	logger = logger.With("old_token_id", rootConfig.TokenId)
+	client, err := b.getNewClient(ctx, rootConfig)
 	if err != nil {
 		return err
 	}
-	token, err := client.CreateToken("vault root token", config.TokenTtlInDays, config.TokenIpRestrictions, config.TokenWorkspaceRestrictions, config.TokenScopes)
+	token, err := client.CreateToken("vault root token", rootConfig.TokenTtlInDays, rootConfig.TokenIpRestrictions, rootConfig.TokenWorkspaceRestrictions, rootConfig.TokenScopes)
 	if err != nil {
+		logger.Error("error while creating root token", "operation", "create_token", "status", apiStatus(nil, err), "error", rootConfig.redact(err.Error()))
 		return err
 	}
 	expiresAt, err := time.Parse(time.RFC3339, token.ExpiresAt)
 	if err != nil {
 		return err
 	}
-	oldTokenId := config.TokenId
-	config.Token = token.Token
-	config.TokenId = token.Id
-	config.TokenExpiresAt = expiresAt
-	config.TokenNoExpiration = false
-	config.TokenScopes = token.Scopes
-	config.TokenIpRestrictions = token.IpRestrictions
-	config.TokenWorkspaceRestrictions = token.WorkspaceRestrictions
-	if config.TokenAutoRotate {
-		config.TokenAutoRotateAt = time.Date(expiresAt.Year(), expiresAt.Month(), expiresAt.Day()-1, expiresAt.Hour(), expiresAt.Minute(), expiresAt.Second(), expiresAt.Nanosecond(), expiresAt.Location())
+	oldTokenId := rootConfig.TokenId
+	rootConfig.Token = token.Token
+	rootConfig.TokenId = token.Id
+	rootConfig.TokenExpiresAt = expiresAt
+	rootConfig.TokenNoExpiration = false
+	rootConfig.TokenScopes = token.Scopes
+	rootConfig.TokenIpRestrictions = token.IpRestrictions
+	rootConfig.TokenWorkspaceRestrictions = token.WorkspaceRestrictions
+	if rootConfig.TokenAutoRotate {
+		rootConfig.TokenAutoRotateAt = time.Date(expiresAt.Year(), expiresAt.Month(), expiresAt.Day()-1, expiresAt.Hour(), expiresAt.Minute(), expiresAt.Second(), expiresAt.Nanosecond(), expiresAt.Location())
 	}
-	err = b.saveConfig(ctx, config, sys.Storage)
+	if workspace != "" {
+		config.putWorkspace(workspace, rootConfig)
+	}
+	err = b.saveConfigLocked(ctx, config, sys.Storage)
 	if err != nil {
+		logger.Error("error while saving rotated root token", "token_id", token.Id, "error", err)
 		_ = client.DeleteToken(token.Id)
 		return err
 	}
-	_ = client.DeleteToken(oldTokenId)
+	if err := client.DeleteToken(oldTokenId); err != nil {
+		logger.Warn("error while deleting old root token", "operation", "delete_token", "status", apiStatus(nil, err), "error", rootConfig.redact(err.Error()))
+	}
+	logger.Info("rotated root token", "token_id", token.Id, "expires_at", expiresAt)
 	return nil
 }
 
-func (b *buddySecretBackend) pathRotateRoot(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
-	err := b.rotateRootToken(ctx, req)
+func (b *buddySecretBackend) pathRotateRoot(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	workspace := d.Get("workspace").(string)
+	if workspace != "" {
+		config, err := b.getConfig(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if config == nil || config.WorkspaceTokens[workspace] == nil {
+			return logical.ErrorResponse("root token of workspace '%s' not provided through config", workspace), nil
+		}
+	}
+	err := b.rotateRootToken(ctx, req, workspace)
 	return nil, err
 }
 
@@ -75,4 +138,6 @@ This path will attempt to generate a new root token for the user.
 The new token will have the sames scopes and filters as the old one.
 The old token will be removed if possible.
 The new token will not be returned from this endpoint or by reading the config.
+If the OAuth application is configured, the refresh token is exchanged instead.
+With workspace set, the root token of the workspace is rotated.
 `
@@ -0,0 +1,360 @@
+package buddysecrets
+
+import (
+	"context"
+	"fmt"
+	"github.com/buddy/api-go-sdk/buddy"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const (
+	scopeSetsStoragePath = "scope-sets"
+	// scopeSetPrefix marks the reference to the scope set in the role scopes
+	scopeSetPrefix = "@"
+	// token scopes missing in the Buddy SDK
+	tokenScopeWorkspaceManage = "WORKSPACE_MANAGE"
+	tokenScopeProjectInfo     = "PROJECT_INFO"
+)
+
+// tokenScopes are the scopes accepted by the Buddy token API
+var tokenScopes = []string{
+	buddy.TokenScopeWorkspace,
+	tokenScopeWorkspaceManage,
+	tokenScopeProjectInfo,
+	buddy.TokenScopeProjectDelete,
+	buddy.TokenScopeRepositoryRead,
+	buddy.TokenScopeRepositoryWrite,
+	buddy.TokenScopeExecutionInfo,
+	buddy.TokenScopeExecutionRun,
+	buddy.TokenScopeExecutionManage,
+	buddy.TokenScopeUserInfo,
+	buddy.TokenScopeUserKey,
+	buddy.TokenScopeUserEmail,
+	buddy.TokenScopeIntegrationInfo,
+	buddy.TokenScopeMemberEmail,
+	buddy.TokenScopeManageEmails,
+	buddy.TokenScopeWebhookInfo,
+	buddy.TokenScopeWebhookAdd,
+	buddy.TokenScopeWebhookManage,
+	buddy.TokenScopeVariableAdd,
+	buddy.TokenScopeVariableInfo,
+	buddy.TokenScopeVariableManage,
+	buddy.TokenScopeTokenInfo,
+	buddy.TokenScopeTokenManage,
+}
+
+type scopeSetEntry struct {
+	Description string   `json:"description"`
+	Scopes      []string `json:"scopes"`
+}
+
+// builtinScopeSets is the catalog of scope sets available in every mount
+var builtinScopeSets = map[string]*scopeSetEntry{
+	"ci-runner": {
+		Description: "Run pipelines and read their executions",
+		Scopes: []string{
+			buddy.TokenScopeWorkspace,
+			tokenScopeProjectInfo,
+			buddy.TokenScopeRepositoryRead,
+			buddy.TokenScopeExecutionInfo,
+			buddy.TokenScopeExecutionRun,
+		},
+	},
+	"pipeline-manager": {
+		Description: "Run and manage pipelines and their variables",
+		Scopes: []string{
+			buddy.TokenScopeWorkspace,
+			tokenScopeProjectInfo,
+			buddy.TokenScopeRepositoryRead,
+			buddy.TokenScopeExecutionInfo,
+			buddy.TokenScopeExecutionRun,
+			buddy.TokenScopeExecutionManage,
+			buddy.TokenScopeVariableInfo,
+			buddy.TokenScopeVariableAdd,
+			buddy.TokenScopeVariableManage,
+		},
+	},
+	"read-only": {
+		Description: "Read the workspace without changing it",
+		Scopes: []string{
+			buddy.TokenScopeWorkspace,
+			tokenScopeProjectInfo,
+			buddy.TokenScopeRepositoryRead,
+			buddy.TokenScopeExecutionInfo,
+			buddy.TokenScopeIntegrationInfo,
+			buddy.TokenScopeWebhookInfo,
+			buddy.TokenScopeVariableInfo,
+			buddy.TokenScopeTokenInfo,
+			buddy.TokenScopeUserInfo,
+		},
+	},
+}
+
+func pathScopeSet(b *buddySecretBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "scope-sets/" + framework.GenericNameRegex("name"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeLowerCaseString,
+				Description: "The name of the scope set, referenced in the role scopes as @name",
+			},
+			"description": {
+				Type:        framework.TypeString,
+				Description: "The description of the scope set.",
+			},
+			"scopes": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "The list of scopes in the set, comma-separated. Scopes are upper-cased.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathScopeSetRead,
+			},
+			logical.CreateOperation: &framework.PathOperation{
+				Callback:                    b.pathScopeSetWrite,
+				ForwardPerformanceSecondary: true,
+				ForwardPerformanceStandby:   true,
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback:                    b.pathScopeSetWrite,
+				ForwardPerformanceSecondary: true,
+				ForwardPerformanceStandby:   true,
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback:                    b.pathScopeSetDelete,
+				ForwardPerformanceSecondary: true,
+				ForwardPerformanceStandby:   true,
+			},
+		},
+		ExistenceCheck:  b.pathScopeSetExistenceCheck,
+		HelpSynopsis:    scopeSetHelpSyn,
+		HelpDescription: scopeSetHelpDesc,
+	}
+}
+
+func saveScopeSet(ctx context.Context, s logical.Storage, c *scopeSetEntry, name string) error {
+	sort.Strings(c.Scopes)
+	entry, err := logical.StorageEntryJSON(fmt.Sprintf("%s/%s", scopeSetsStoragePath, name), c)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+// getScopeSet returns the scope set from the built-in catalog or from the storage
+func getScopeSet(ctx context.Context, name string, s logical.Storage) (*scopeSetEntry, error) {
+	if set, ok := builtinScopeSets[name]; ok {
+		return set, nil
+	}
+	entry, err := s.Get(ctx, fmt.Sprintf("%s/%s", scopeSetsStoragePath, name))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	set := new(scopeSetEntry)
+	if err := entry.DecodeJSON(set); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// validateScopes checks that the scopes are known to Buddy and that the referenced scope sets exist.
+// The stored scopes were accepted before, so they are kept even if missing from the known scopes
+func validateScopes(ctx context.Context, s logical.Storage, scopes []string, stored []string) error {
+	return checkScopes(scopes, stored, func(name string) (bool, error) {
+		set, err := getScopeSet(ctx, name, s)
+		return set != nil, err
+	})
+}
+
+// checkScopes checks the scopes with the given lookup of the scope sets, nil lookup
+// forbids the references. Unknown scopes are allowed only if already stored
+func checkScopes(scopes []string, stored []string, setExists func(name string) (bool, error)) error {
+	for _, scope := range scopes {
+		if name, ok := strings.CutPrefix(scope, scopeSetPrefix); ok {
+			if setExists == nil {
+				return fmt.Errorf("scope set '%s' cannot be referenced here", name)
+			}
+			exists, err := setExists(name)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return fmt.Errorf("scope set '%s' does not exist", name)
+			}
+			continue
+		}
+		if !isTokenScope(scope) && !slices.Contains(stored, scope) {
+			return fmt.Errorf("unknown scope '%s'", scope)
+		}
+	}
+	return nil
+}
+
+func isTokenScope(scope string) bool {
+	for _, s := range tokenScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// expandScopes replaces the references to scope sets with their scopes
+func (r *roleResolver) expandScopes(scopes []string) ([]string, error) {
+	seen := map[string]bool{}
+	expanded := make([]string, 0, len(scopes))
+	add := func(scope string) {
+		if !seen[scope] {
+			seen[scope] = true
+			expanded = append(expanded, scope)
+		}
+	}
+	for _, scope := range scopes {
+		name, ok := strings.CutPrefix(scope, scopeSetPrefix)
+		if !ok {
+			add(scope)
+			continue
+		}
+		set, err := r.scopeSet(name)
+		if err != nil {
+			return nil, err
+		}
+		if set == nil {
+			return nil, fmt.Errorf("scope set '%s' does not exist", name)
+		}
+		for _, setScope := range set.Scopes {
+			add(setScope)
+		}
+	}
+	sort.Strings(expanded)
+	return expanded, nil
+}
+
+func usesScopeSets(scopes []string) bool {
+	for _, scope := range scopes {
+		if strings.HasPrefix(scope, scopeSetPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeScopes upper-cases the scopes and lower-cases the references to scope sets
+func normalizeScopes(scopes []string) []string {
+	for i, scope := range scopes {
+		if strings.HasPrefix(scope, scopeSetPrefix) {
+			scopes[i] = strings.ToLower(scope)
+		} else {
+			scopes[i] = strings.ToUpper(scope)
+		}
+	}
+	return scopes
+}
+
+func (b *buddySecretBackend) pathScopeSetExistenceCheck(ctx context.Context, req *logical.Request, d *framework.FieldData) (bool, error) {
+	name := d.Get("name").(string)
+	set, err := getScopeSet(ctx, name, req.Storage)
+	if err != nil {
+		return false, err
+	}
+	return set != nil, nil
+}
+
+func (b *buddySecretBackend) pathScopeSetDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	if _, ok := builtinScopeSets[name]; ok {
+		return logical.ErrorResponse("built-in scope set '%s' cannot be deleted", name), nil
+	}
+	ref := scopeSetPrefix + name
+	doc, err := loadDocument(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	for roleName, role := range doc.Roles {
+		for _, scope := range role.Scopes {
+			if scope == ref {
+				return logical.ErrorResponse("scope set '%s' is used by role '%s'", name, roleName), nil
+			}
+		}
+	}
+	for templateName, template := range doc.RoleTemplates {
+		for _, scope := range template.Scopes {
+			if scope == ref {
+				return logical.ErrorResponse("scope set '%s' is used by role template '%s'", name, templateName), nil
+			}
+		}
+	}
+	err = req.Storage.Delete(ctx, fmt.Sprintf("%s/%s", scopeSetsStoragePath, name))
+	return nil, err
+}
+
+func (b *buddySecretBackend) pathScopeSetRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	set, err := getScopeSet(ctx, name, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if set == nil {
+		return nil, nil
+	}
+	_, builtin := builtinScopeSets[name]
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"description": set.Description,
+			"scopes":      set.Scopes,
+			"builtin":     builtin,
+		},
+	}
+	return resp, nil
+}
+
+func (b *buddySecretBackend) pathScopeSetWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	if _, ok := builtinScopeSets[name]; ok {
+		return logical.ErrorResponse("built-in scope set '%s' cannot be changed", name), nil
+	}
+	set, err := getScopeSet(ctx, name, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if set == nil {
+		if req.Operation == logical.UpdateOperation {
+			return logical.ErrorResponse("scope set not found during update operation"), nil
+		}
+		set = &scopeSetEntry{}
+	}
+	if description, ok := d.GetOk("description"); ok {
+		set.Description = description.(string)
+	}
+	stored := set.Scopes
+	if scopes, ok := d.GetOk("scopes"); ok {
+		set.Scopes = normalizeScopes(scopes.([]string))
+	}
+	if len(set.Scopes) == 0 {
+		return logical.ErrorResponse("scopes must be provided"), nil
+	}
+	// scope sets cannot reference other sets, so they never form cycles
+	if err := checkScopes(set.Scopes, stored, nil); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	err = saveScopeSet(ctx, req.Storage, set, name)
+	return nil, err
+}
+
+const scopeSetHelpSyn = "Manage the named sets of scopes referenced by the roles."
+
+const scopeSetHelpDesc = `
+This path allows you to read and write scope sets. The role scopes accept
+references to the scope sets, e.g. "scopes=@ci-runner,WEBHOOK_INFO", which are
+expanded when credentials are requested. The built-in scope sets (ci-runner,
+pipeline-manager, read-only) are available in every mount and cannot be
+changed.
+`
@@ -0,0 +1,45 @@
+package buddysecrets
+
+import (
+	"context"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"sort"
+)
+
+func pathScopeSets(b *buddySecretBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "scope-sets/?",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ListOperation: &framework.PathOperation{
+				Callback: b.pathScopeSetsList,
+			},
+		},
+		HelpSynopsis:    scopeSetsHelpSyn,
+		HelpDescription: scopeSetsHelpDesc,
+	}
+}
+
+func (b *buddySecretBackend) pathScopeSetsList(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	sets, err := req.Storage.List(ctx, scopeSetsStoragePath+"/")
+	if err != nil {
+		return nil, err
+	}
+	keyInfo := map[string]interface{}{}
+	for _, name := range sets {
+		keyInfo[name] = map[string]interface{}{
+			"builtin": false,
+		}
+	}
+	for name := range builtinScopeSets {
+		sets = append(sets, name)
+		keyInfo[name] = map[string]interface{}{
+			"builtin": true,
+		}
+	}
+	sort.Strings(sets)
+	return logical.ListResponseWithInfo(sets, keyInfo), nil
+}
+
+const scopeSetsHelpSyn = "List existing scope sets."
+const scopeSetsHelpDesc = "List the built-in and the custom scope sets by name."
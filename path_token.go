@@ -3,8 +3,11 @@ package buddysecrets
 import (
 	"context"
 	"fmt"
+	"github.com/armon/go-metrics"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/wrapping"
 	"github.com/hashicorp/vault/sdk/logical"
+	"time"
 )
 
 const (
@@ -25,31 +28,82 @@ func (b *buddySecretBackend) tokenRenew(ctx context.Context, req *logical.Reques
 	if !ok {
 		return nil, fmt.Errorf("internal data 'role' not found")
 	}
-	role, err := getRole(ctx, roleRaw.(string), req.Storage)
+	role, err := getEffectiveRole(ctx, roleRaw.(string), req.Storage)
 	if err != nil {
 		return nil, err
 	}
 	if role == nil {
 		return nil, nil
 	}
+	logger := b.requestLogger(req).With("role", roleRaw)
+	if tokenId, ok := req.Secret.InternalData["token_id"].(string); ok {
+		logger = logger.With("token_id", tokenId)
+		token, err := getIssuedToken(ctx, tokenId, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if token != nil {
+			token.ExpiresAt = b.leaseExpiration(role.Ttl)
+			if err := saveIssuedToken(ctx, req.Storage, token); err != nil {
+				return nil, err
+			}
+		}
+	}
+	incrCounter([]string{"creds", "renewed"}, metrics.Label{Name: "role", Value: roleRaw.(string)})
+	logger.Debug("renewed token", "ttl", role.Ttl)
 	resp := &logical.Response{Secret: req.Secret}
 	resp.Secret.TTL = role.Ttl
 	resp.Secret.MaxTTL = role.MaxTTL
 	return resp, nil
 }
 
-func (b *buddySecretBackend) tokenRevoke(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+func (b *buddySecretBackend) tokenRevoke(ctx context.Context, req *logical.Request, _ *framework.FieldData) (_ *logical.Response, err error) {
+	defer func() {
+		roleName, _ := req.Secret.InternalData["role"].(string)
+		incrCounter([]string{"creds", "revoked"},
+			metrics.Label{Name: "role", Value: roleName},
+			metrics.Label{Name: "status", Value: metricsStatus(err)})
+	}()
 	tokenIdRaw, ok := req.Secret.InternalData["token_id"]
 	if !ok {
 		return nil, fmt.Errorf("internal data 'token_id' not found")
 	}
 	tokenId := tokenIdRaw.(string)
-	client, err := b.getClient(ctx, req.Storage)
+	logger := b.requestLogger(req).With("role", req.Secret.InternalData["role"], "token_id", tokenId)
+	// the token is deleted by the root token of the workspace which created it
+	rootWorkspace, _ := req.Secret.InternalData["root_workspace"].(string)
+	err = b.deleteWorkspaceToken(ctx, req.Storage, rootWorkspace, tokenId)
+	// token could have been already deleted by revoke-all or revoke-orphans
+	if err != nil && !isNotFound(err) {
+		logger.Error("error while deleting token", "operation", "delete_token", "status", apiStatus(nil, err), "error", err)
+		return nil, err
+	}
+	if err != nil {
+		logger.Debug("token already deleted in buddy")
+	}
+	issued, err := getIssuedToken(ctx, tokenId, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	// revoke-all and revoke-orphans remove the record and release the lease themselves
+	if issued == nil {
+		logger.Info("revoked token")
+		return nil, nil
+	}
+	err = deleteIssuedToken(ctx, req.Storage, tokenId)
 	if err != nil {
 		return nil, err
 	}
-	err = client.DeleteToken(tokenId)
-	return nil, err
+	// leases issued before usage tracking have no entity id and were never counted
+	if entityIdRaw, ok := req.Secret.InternalData["entity_id"]; ok {
+		err = b.releaseLease(ctx, req.Storage, req.Secret.InternalData["role"].(string), entityIdRaw.(string))
+		if err != nil {
+			logger.Error("error while releasing lease", "error", err)
+			return nil, err
+		}
+	}
+	logger.Info("revoked token")
+	return nil, nil
 }
 
 func (b *buddySecretBackend) pathTokenRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
@@ -57,14 +111,11 @@ func (b *buddySecretBackend) pathTokenRead(ctx context.Context, req *logical.Req
 	if err != nil {
 		return nil, err
 	}
-	if config == nil || config.Token == "" {
+	if config == nil || !config.hasRootCredential() {
 		return logical.ErrorResponse("root token not provided through config"), nil
 	}
-	client, err := b.getClient(ctx, req.Storage)
-	if err != nil {
-		return nil, err
-	}
 	roleName := d.Get("role").(string)
+	logger := b.requestLogger(req).With("role", roleName)
 	role, err := getRole(ctx, roleName, req.Storage)
 	if err != nil {
 		return nil, err
@@ -72,23 +123,139 @@ func (b *buddySecretBackend) pathTokenRead(ctx context.Context, req *logical.Req
 	if role == nil {
 		return logical.ErrorResponse(fmt.Sprintf("role '%s' does not exist", roleName)), nil
 	}
-	token, err := client.CreateToken(fmt.Sprintf("vault token for '%s' role", roleName), TokenDefaultExpiration, role.IpRestrictions, role.WorkspaceRestrictions, role.Scopes)
+	role, err = resolveRole(ctx, req.Storage, role)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	// roles written before the policy was changed are checked again
+	if err := b.checkPolicy(ctx, req.Storage, role); err != nil {
+		logger.Warn("role violates the mount policy", "error", err)
+		return logical.ErrorResponse("role '%s' violates the mount policy: %s", roleName, err), nil
+	}
+	// checked before the token is created, so the rejected reads leave nothing behind
+	wrapInfo, err := roleWrapInfo(req, role)
+	if err != nil {
+		logger.Warn("credentials read without the required response wrapping", "error", err)
+		return logical.ErrorResponse("role '%s' %s", roleName, err), nil
+	}
+	if role.BindToClientIp {
+		// the token is restricted to the client only, which is narrower than the ranges of the role
+		role.IpRestrictions, err = bindClientIp(req, role)
+		if err != nil {
+			logger.Warn("client address not allowed by the role", "error", err)
+			return logical.ErrorResponse("role '%s' %s", roleName, err), nil
+		}
+	}
+	// the root token restricted to the workspaces of the role issues its tokens
+	rootWorkspace, err := config.roleWorkspace(role)
+	if err != nil {
+		logger.Warn("role cannot be issued by any root token", "error", err)
+		return logical.ErrorResponse("role '%s': %s", roleName, err), nil
+	}
+	client, err := b.getWorkspaceClient(ctx, req.Storage, rootWorkspace)
+	if err != nil {
+		return nil, err
+	}
+	rootTokenId := config.TokenId
+	if rootWorkspace != "" {
+		rootTokenId = config.WorkspaceTokens[rootWorkspace].TokenId
+		logger = logger.With("root_workspace", rootWorkspace)
+	}
+	limitResp, err := b.reserveLease(ctx, req.Storage, roleName, role, req.EntityID)
+	if err != nil || limitResp != nil {
+		return limitResp, err
+	}
+	var tokenId, tokenValue string
+	if role.PoolSize > 0 {
+		pooled, err := b.takePooledToken(ctx, req.Storage, roleName, role)
+		if err != nil {
+			logger.Error("error while taking pooled token", "error", err)
+			_ = b.releaseLease(ctx, req.Storage, roleName, req.EntityID)
+			return nil, err
+		}
+		if pooled != nil {
+			tokenId = pooled.TokenId
+			tokenValue = pooled.Token
+			rootTokenId = pooled.RootTokenId
+			rootWorkspace = pooled.RootWorkspace
+		}
+		b.refillPoolAsync(req.Storage, roleName)
+	}
+	if tokenId == "" {
+		token, err := client.CreateToken(fmt.Sprintf("vault token for '%s' role", roleName), TokenDefaultExpiration, role.IpRestrictions, role.WorkspaceRestrictions, role.Scopes)
+		if err != nil {
+			logger.Error("error while creating token", "operation", "create_token", "status", apiStatus(nil, err), "error", err)
+			_ = b.releaseLease(ctx, req.Storage, roleName, req.EntityID)
+			return nil, err
+		}
+		tokenId = token.Id
+		tokenValue = token.Token
+	}
+	err = saveIssuedToken(ctx, req.Storage, &issuedToken{
+		TokenId:               tokenId,
+		Role:                  roleName,
+		RoleVersion:           role.Version,
+		EntityId:              req.EntityID,
+		CreatedAt:             time.Now(),
+		ExpiresAt:             b.leaseExpiration(role.Ttl),
+		Scopes:                role.Scopes,
+		IpRestrictions:        role.IpRestrictions,
+		WorkspaceRestrictions: role.WorkspaceRestrictions,
+		RootTokenId:           rootTokenId,
+		RootWorkspace:         rootWorkspace,
+	})
 	if err != nil {
+		logger.Error("error while saving issued token", "token_id", tokenId, "error", err)
+		incrCounter([]string{"creds", "rollback"}, metrics.Label{Name: "role", Value: roleName})
+		// the pooled token could have been created by another root token than the current one of the role
+		if err := b.deleteWorkspaceToken(ctx, req.Storage, rootWorkspace, tokenId); err != nil && !isNotFound(err) {
+			logger.Error("error while deleting token which was not saved", "token_id", tokenId, "error", err)
+		}
+		_ = b.releaseLease(ctx, req.Storage, roleName, req.EntityID)
 		return nil, err
 	}
 	data := map[string]interface{}{
-		"token": token.Token,
+		"token": tokenValue,
+	}
+	if role.BindToClientIp {
+		data["ip_restrictions"] = role.IpRestrictions
 	}
 	internalData := map[string]interface{}{
-		"role":     roleName,
-		"token_id": token.Id,
+		"role":         roleName,
+		"role_version": role.Version,
+		"token_id":     tokenId,
+		"entity_id":    req.EntityID,
+	}
+	if rootWorkspace != "" {
+		internalData["root_workspace"] = rootWorkspace
 	}
+	incrCounter([]string{"creds", "issued"}, metrics.Label{Name: "role", Value: roleName})
+	logger.Info("issued token", "token_id", tokenId, "root_token_id", rootTokenId, "role_version", role.Version, "delivery", role.Delivery)
 	resp := b.Secret(SecretTypeToken).Response(data, internalData)
 	resp.Secret.TTL = role.Ttl
 	resp.Secret.MaxTTL = role.MaxTTL
+	resp.WrapInfo = wrapInfo
 	return resp, nil
 }
 
+// roleWrapInfo checks the response wrapping of the request against wrap_ttl of the role and returns
+// the wrapping the engine applies itself with the wrapped delivery
+func roleWrapInfo(req *logical.Request, role *roleEntry) (*wrapping.ResponseWrapInfo, error) {
+	if role.WrapTTL == 0 {
+		return nil, nil
+	}
+	if req.WrapInfo != nil && req.WrapInfo.TTL > 0 {
+		if req.WrapInfo.TTL > role.WrapTTL {
+			return nil, fmt.Errorf("allows response wrapping for at most %d seconds", int64(role.WrapTTL.Seconds()))
+		}
+		return nil, nil
+	}
+	if role.Delivery == roleDeliveryWrapped {
+		return &wrapping.ResponseWrapInfo{TTL: role.WrapTTL}, nil
+	}
+	return nil, fmt.Errorf("requires response wrapping, read the credentials with wrap ttl of at most %d seconds", int64(role.WrapTTL.Seconds()))
+}
+
 func pathToken(b *buddySecretBackend) *framework.Path {
 	return &framework.Path{
 		Pattern: fmt.Sprintf("creds/%s", framework.GenericNameRegex("role")),
@@ -100,9 +267,9 @@ func pathToken(b *buddySecretBackend) *framework.Path {
 		},
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.ReadOperation: &framework.PathOperation{
-				Callback:                    b.pathTokenRead,
-				ForwardPerformanceSecondary: true,
-				ForwardPerformanceStandby:   true,
+				Callback: b.pathTokenRead,
+				// performance secondaries issue their own tokens, tracked in the local storage
+				ForwardPerformanceStandby: true,
 			},
 		},
 		HelpSynopsis:    tokenHelpSyn,
@@ -0,0 +1,259 @@
+package buddysecrets
+
+import (
+	"context"
+	"errors"
+	"github.com/hashicorp/vault/sdk/logical"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingStorage fails to write the entries with the prefix
+type failingStorage struct {
+	logical.Storage
+	prefix string
+}
+
+func (s *failingStorage) Put(ctx context.Context, entry *logical.StorageEntry) error {
+	if strings.HasPrefix(entry.Key, s.prefix) {
+		return errors.New("storage unavailable")
+	}
+	return s.Storage.Put(ctx, entry)
+}
+
+func TestCredsIssueAndRevoke(t *testing.T) {
+	f := newFakeBuddy(t)
+	b, s := getTestBackend(t, 0)
+	root := testConfigure(t, b, s, f)
+	testOk(t, b, s, logical.CreateOperation, "roles/r1", map[string]interface{}{
+		"scopes":          "WORKSPACE,EXECUTION_RUN",
+		"ip_restrictions": "10.0.0.0/8",
+		"ttl":             600,
+	})
+
+	resp := testOk(t, b, s, logical.ReadOperation, "creds/r1", nil)
+	if resp.Data["token"] == "" {
+		t.Fatal("expected the token in the response")
+	}
+	tokenId := resp.Secret.InternalData["token_id"].(string)
+	f.lock.Lock()
+	token := f.tokens[resp.Data["token"].(string)]
+	f.lock.Unlock()
+	if token == nil || token.Id != tokenId {
+		t.Fatalf("token %s not created in buddy", tokenId)
+	}
+	if !slices.Equal(token.Scopes, []string{"EXECUTION_RUN", "WORKSPACE"}) || !slices.Equal(token.IpRestrictions, []string{"10.0.0.0/8"}) {
+		t.Fatalf("token created with unexpected scopes %v and ip restrictions %v", token.Scopes, token.IpRestrictions)
+	}
+	if resp.Secret.TTL.Seconds() != 600 {
+		t.Fatalf("expected the ttl of the role, got %s", resp.Secret.TTL)
+	}
+	issued, err := getIssuedToken(context.Background(), tokenId, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issued == nil || issued.Role != "r1" || issued.RootTokenId != root.Id {
+		t.Fatalf("unexpected record of the issued token %+v", issued)
+	}
+
+	if err := testRevoke(t, b, s, resp.Secret); err != nil {
+		t.Fatal(err)
+	}
+	if f.exists(tokenId) {
+		t.Fatal("revoked token must be deleted in buddy")
+	}
+	issued, err = getIssuedToken(context.Background(), tokenId, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issued != nil {
+		t.Fatal("record of the revoked token must be deleted")
+	}
+	// the token could have been deleted by revoke-all
+	if err := testRevoke(t, b, s, resp.Secret); err != nil {
+		t.Fatalf("revoking the deleted token must succeed, got %s", err)
+	}
+}
+
+func TestCredsFromPool(t *testing.T) {
+	f := newFakeBuddy(t)
+	b, s := getTestBackend(t, 0)
+	testConfigure(t, b, s, f)
+	ctx := context.Background()
+	testOk(t, b, s, logical.CreateOperation, "roles/r1", map[string]interface{}{
+		"scopes":    "WORKSPACE",
+		"pool_size": 1,
+	})
+	if err := b.refillPools(ctx, s, "r1"); err != nil {
+		t.Fatal(err)
+	}
+	pooled, err := listPooledTokens(ctx, s, "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pooled) != 1 {
+		t.Fatalf("expected 1 pooled token, got %d", len(pooled))
+	}
+
+	resp := testOk(t, b, s, logical.ReadOperation, "creds/r1", nil)
+	if resp.Secret.InternalData["token_id"] != pooled[0].TokenId || resp.Data["token"] != pooled[0].Token {
+		t.Fatal("expected the pooled token to be handed out")
+	}
+	if err := b.refillPools(ctx, s, "r1"); err != nil {
+		t.Fatal(err)
+	}
+	refilled, err := listPooledTokens(ctx, s, "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refilled) != 1 || refilled[0].TokenId == pooled[0].TokenId {
+		t.Fatalf("expected the pool to be refilled with a new token, got %v", refilled)
+	}
+
+	// the stale pooled tokens are replaced when the role changes
+	testOk(t, b, s, logical.UpdateOperation, "roles/r1", map[string]interface{}{
+		"scopes": "WORKSPACE,EXECUTION_RUN",
+	})
+	if err := b.refillPools(ctx, s, "r1"); err != nil {
+		t.Fatal(err)
+	}
+	replaced, err := listPooledTokens(ctx, s, "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replaced) != 1 || replaced[0].TokenId == refilled[0].TokenId || f.exists(refilled[0].TokenId) {
+		t.Fatal("stale pooled token must be replaced and deleted in buddy")
+	}
+}
+
+func TestCredsPooledTokenDeletedWhenNotSaved(t *testing.T) {
+	f := newFakeBuddy(t)
+	b, s := getTestBackend(t, 0)
+	testConfigure(t, b, s, f)
+	ctx := context.Background()
+	testOk(t, b, s, logical.CreateOperation, "roles/r1", map[string]interface{}{
+		"scopes":                 "WORKSPACE",
+		"workspace_restrictions": "acme",
+		"pool_size":              1,
+	})
+	// pooled by the root credentials before the workspace root token was configured
+	if err := b.refillPools(ctx, s, "r1"); err != nil {
+		t.Fatal(err)
+	}
+	pooled, err := listPooledTokens(ctx, s, "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pooled) != 1 || pooled[0].RootWorkspace != "" {
+		t.Fatalf("expected 1 token pooled by the root credentials, got %v", pooled)
+	}
+	acme := f.addRootToken("acme")
+	testOk(t, b, s, logical.UpdateOperation, "config", map[string]interface{}{
+		"workspace_tokens": map[string]interface{}{
+			"acme": acme.Token,
+		},
+	})
+	// the workspace root token of the role can no longer delete tokens
+	f.lock.Lock()
+	delete(f.tokens, acme.Token)
+	f.lock.Unlock()
+
+	_, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/r1",
+		Storage:   &failingStorage{Storage: s, prefix: tokensStoragePath + "/"},
+	})
+	if err == nil {
+		t.Fatal("creds must fail when the issued token cannot be saved")
+	}
+	if f.exists(pooled[0].TokenId) {
+		t.Fatal("pooled token must be deleted by the root token which created it")
+	}
+}
+
+func TestCredsResponseWrapping(t *testing.T) {
+	f := newFakeBuddy(t)
+	b, s := getTestBackend(t, 0)
+	testConfigure(t, b, s, f)
+	ctx := context.Background()
+	testOk(t, b, s, logical.CreateOperation, "roles/required", map[string]interface{}{
+		"scopes":   "WORKSPACE",
+		"wrap_ttl": 60,
+	})
+	testOk(t, b, s, logical.CreateOperation, "roles/wrapped", map[string]interface{}{
+		"scopes":   "WORKSPACE",
+		"wrap_ttl": 60,
+		"delivery": roleDeliveryWrapped,
+	})
+	readCreds := func(role string, wrapTTL time.Duration) *logical.Response {
+		t.Helper()
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "creds/" + role,
+			Storage:   s,
+		}
+		if wrapTTL > 0 {
+			req.WrapInfo = &logical.RequestWrapInfo{TTL: wrapTTL}
+		}
+		resp, err := b.HandleRequest(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	issued := func() int {
+		t.Helper()
+		tokens, err := listIssuedTokens(ctx, s, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(tokens)
+	}
+
+	tests := []struct {
+		name    string
+		role    string
+		wrapTTL time.Duration
+		// wantWrap is the wrap ttl of the response set by the role
+		wantWrap time.Duration
+		wantErr  bool
+	}{
+		{name: "unwrapped request", role: "required", wantErr: true},
+		{name: "wrap ttl over the role limit", role: "required", wrapTTL: 2 * time.Minute, wantErr: true},
+		{name: "wrapped request", role: "required", wrapTTL: 30 * time.Second},
+		{name: "wrapped delivery", role: "wrapped", wantWrap: time.Minute},
+		{name: "wrapped delivery with shorter request wrap ttl", role: "wrapped", wrapTTL: 30 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := issued()
+			resp := readCreds(tt.role, tt.wrapTTL)
+			if tt.wantErr {
+				if !resp.IsError() {
+					t.Fatal("credentials must be refused")
+				}
+				if issued() != before {
+					t.Fatal("refused read must not issue a token")
+				}
+				return
+			}
+			if resp.IsError() {
+				t.Fatal(resp.Error())
+			}
+			if tt.wantWrap == 0 {
+				if resp.WrapInfo != nil {
+					t.Fatalf("response must be wrapped as requested, got the wrap info of the role %+v", resp.WrapInfo)
+				}
+				return
+			}
+			if resp.WrapInfo == nil || resp.WrapInfo.TTL != tt.wantWrap {
+				t.Fatalf("expected the response wrapped for %s, got %+v", tt.wantWrap, resp.WrapInfo)
+			}
+			if resp.Data["token"] == "" || resp.Secret == nil {
+				t.Fatal("wrapped response must carry the token and its lease")
+			}
+		})
+	}
+}
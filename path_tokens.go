@@ -0,0 +1,202 @@
+package buddysecrets
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"time"
+)
+
+const (
+	tokensStoragePath = "tokens"
+)
+
+// issuedToken is the record of the token issued by the creds endpoint, without the token value
+type issuedToken struct {
+	TokenId               string    `json:"token_id"`
+	Role                  string    `json:"role"`
+	RoleVersion           int       `json:"role_version"`
+	EntityId              string    `json:"entity_id"`
+	CreatedAt             time.Time `json:"created_at"`
+	ExpiresAt             time.Time `json:"expires_at"`
+	Scopes                []string  `json:"scopes"`
+	IpRestrictions        []string  `json:"ip_restrictions"`
+	WorkspaceRestrictions []string  `json:"workspace_restrictions"`
+	RootTokenId           string    `json:"root_token_id"`
+	RootWorkspace         string    `json:"root_workspace"`
+}
+
+func pathTokens(b *buddySecretBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "tokens/?",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.pathTokensList,
+				},
+			},
+			HelpSynopsis:    tokensHelpSyn,
+			HelpDescription: tokensHelpDesc,
+		},
+		{
+			Pattern: "tokens/" + framework.GenericNameRegex("token_id"),
+			Fields: map[string]*framework.FieldSchema{
+				"token_id": {
+					Type:        framework.TypeString,
+					Description: "The ID of the issued Buddy token",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathTokensRead,
+				},
+			},
+			HelpSynopsis:    tokensHelpSyn,
+			HelpDescription: tokensHelpDesc,
+		},
+		{
+			Pattern: "roles/" + framework.GenericNameRegex("name") + "/tokens/?",
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeLowerCaseString,
+					Description: "The name of the role",
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.pathRoleTokensList,
+				},
+			},
+			HelpSynopsis:    roleTokensHelpSyn,
+			HelpDescription: roleTokensHelpDesc,
+		},
+	}
+}
+
+func saveIssuedToken(ctx context.Context, s logical.Storage, token *issuedToken) error {
+	entry, err := logical.StorageEntryJSON(fmt.Sprintf("%s/%s", tokensStoragePath, token.TokenId), token)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+func getIssuedToken(ctx context.Context, tokenId string, s logical.Storage) (*issuedToken, error) {
+	entry, err := s.Get(ctx, fmt.Sprintf("%s/%s", tokensStoragePath, tokenId))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	token := new(issuedToken)
+	if err := entry.DecodeJSON(token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func deleteIssuedToken(ctx context.Context, s logical.Storage, tokenId string) error {
+	return s.Delete(ctx, fmt.Sprintf("%s/%s", tokensStoragePath, tokenId))
+}
+
+// listIssuedTokens returns the records of the issued tokens, optionally filtered by role
+func listIssuedTokens(ctx context.Context, s logical.Storage, roleName string) ([]*issuedToken, error) {
+	ids, err := s.List(ctx, tokensStoragePath+"/")
+	if err != nil {
+		return nil, err
+	}
+	tokens := make([]*issuedToken, 0, len(ids))
+	for _, id := range ids {
+		token, err := getIssuedToken(ctx, id, s)
+		if err != nil {
+			return nil, err
+		}
+		if token == nil || (roleName != "" && token.Role != roleName) {
+			continue
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+// leaseExpiration returns when the lease with the given ttl expires, the mount default is used for 0
+func (b *buddySecretBackend) leaseExpiration(ttl time.Duration) time.Time {
+	if ttl == 0 {
+		ttl = b.System().DefaultLeaseTTL()
+	}
+	return time.Now().Add(ttl)
+}
+
+func (t *issuedToken) responseData() map[string]interface{} {
+	return map[string]interface{}{
+		"token_id":               t.TokenId,
+		"role":                   t.Role,
+		"role_version":           t.RoleVersion,
+		"entity_id":              t.EntityId,
+		"created_at":             t.CreatedAt,
+		"expires_at":             t.ExpiresAt,
+		"scopes":                 t.Scopes,
+		"ip_restrictions":        t.IpRestrictions,
+		"workspace_restrictions": t.WorkspaceRestrictions,
+		"root_token_id":          t.RootTokenId,
+		"root_workspace":         t.RootWorkspace,
+	}
+}
+
+func (b *buddySecretBackend) pathTokensList(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	tokens, err := listIssuedTokens(ctx, req.Storage, "")
+	if err != nil {
+		return nil, err
+	}
+	return issuedTokensListResponse(tokens), nil
+}
+
+func (b *buddySecretBackend) pathRoleTokensList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	tokens, err := listIssuedTokens(ctx, req.Storage, d.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+	return issuedTokensListResponse(tokens), nil
+}
+
+func issuedTokensListResponse(tokens []*issuedToken) *logical.Response {
+	keys := make([]string, 0, len(tokens))
+	keyInfo := map[string]interface{}{}
+	for _, token := range tokens {
+		keys = append(keys, token.TokenId)
+		keyInfo[token.TokenId] = map[string]interface{}{
+			"role":       token.Role,
+			"entity_id":  token.EntityId,
+			"created_at": token.CreatedAt,
+			"expires_at": token.ExpiresAt,
+		}
+	}
+	return logical.ListResponseWithInfo(keys, keyInfo)
+}
+
+func (b *buddySecretBackend) pathTokensRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	token, err := getIssuedToken(ctx, d.Get("token_id").(string), req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if token == nil {
+		return nil, nil
+	}
+	return &logical.Response{
+		Data: token.responseData(),
+	}, nil
+}
+
+const tokensHelpSyn = "List and read the Buddy tokens issued by the engine."
+const tokensHelpDesc = `
+This path lists the Buddy tokens with active leases. Every record has the
+role, the entity which requested the token, the creation and expiration
+time and the scopes. Token values are never stored.
+`
+
+const roleTokensHelpSyn = "List the Buddy tokens issued for the role."
+const roleTokensHelpDesc = `
+This path lists the Buddy tokens with active leases issued for the role.
+`
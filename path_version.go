@@ -0,0 +1,42 @@
+package buddysecrets
+
+import (
+	"context"
+	"github.com/buddy/vault-plugin-secrets-engine-buddy/version"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"runtime"
+)
+
+func pathVersion(b *buddySecretBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "version",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathVersionRead,
+			},
+		},
+		HelpSynopsis:    versionHelpSyn,
+		HelpDescription: versionHelpDesc,
+	}
+}
+
+func (b *buddySecretBackend) pathVersionRead(_ context.Context, _ *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"version":           version.Version,
+			"commit":            version.Commit(),
+			"build_date":        version.BuildDate,
+			"go_version":        runtime.Version(),
+			"buddy_sdk_version": version.Dependency("github.com/buddy/api-go-sdk"),
+			"vault_sdk_version": version.Dependency("github.com/hashicorp/vault/sdk"),
+		},
+	}, nil
+}
+
+const versionHelpSyn = "Read the version of the plugin."
+const versionHelpDesc = `
+This path returns the version of the plugin reported to Vault, the commit and
+the date of the build, and the versions of Go and the Buddy and Vault SDKs the
+plugin was built with.
+`
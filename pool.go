@@ -0,0 +1,318 @@
+package buddysecrets
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/logical"
+	"strings"
+	"time"
+)
+
+const (
+	poolStoragePath = "pool"
+)
+
+// pooledToken is the pre-created token waiting to be handed out by the creds endpoint
+type pooledToken struct {
+	TokenId     string `json:"token_id"`
+	Token       string `json:"token"`
+	Fingerprint string `json:"fingerprint"`
+	RootTokenId string `json:"root_token_id"`
+	// RootWorkspace is the workspace of the root token which created the token, empty for the root credentials
+	RootWorkspace string    `json:"root_workspace"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// roleFingerprint identifies the token parameters of the role, pooled tokens created
+// with different parameters are stale
+func roleFingerprint(role *roleEntry) string {
+	h := sha256.New()
+	h.Write([]byte(strings.Join(role.Scopes, ",")))
+	h.Write([]byte{0})
+	h.Write([]byte(strings.Join(role.IpRestrictions, ",")))
+	h.Write([]byte{0})
+	h.Write([]byte(strings.Join(role.WorkspaceRestrictions, ",")))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func listPooledTokens(ctx context.Context, s logical.Storage, roleName string) ([]*pooledToken, error) {
+	ids, err := s.List(ctx, fmt.Sprintf("%s/%s/", poolStoragePath, roleName))
+	if err != nil {
+		return nil, err
+	}
+	tokens := make([]*pooledToken, 0, len(ids))
+	for _, id := range ids {
+		entry, err := s.Get(ctx, fmt.Sprintf("%s/%s/%s", poolStoragePath, roleName, id))
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			continue
+		}
+		token := new(pooledToken)
+		if err := entry.DecodeJSON(token); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+func putPooledToken(ctx context.Context, s logical.Storage, roleName string, token *pooledToken) error {
+	entry, err := secretEntryJSON(fmt.Sprintf("%s/%s/%s", poolStoragePath, roleName, token.TokenId), token)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+func deletePooledToken(ctx context.Context, s logical.Storage, roleName string, tokenId string) error {
+	return s.Delete(ctx, fmt.Sprintf("%s/%s/%s", poolStoragePath, roleName, tokenId))
+}
+
+// takePooledToken removes the token matching the role from the pool and returns it.
+// It returns nil when the pool is empty
+func (b *buddySecretBackend) takePooledToken(ctx context.Context, s logical.Storage, roleName string, role *roleEntry) (*pooledToken, error) {
+	b.poolLock.Lock()
+	defer b.poolLock.Unlock()
+	tokens, err := listPooledTokens(ctx, s, roleName)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint := roleFingerprint(role)
+	for _, token := range tokens {
+		if token.Fingerprint != fingerprint {
+			continue
+		}
+		if err := deletePooledToken(ctx, s, roleName, token.TokenId); err != nil {
+			return nil, err
+		}
+		return token, nil
+	}
+	return nil, nil
+}
+
+// refillPoolAsync queues the refill of the pools of the given roles (or every role if none is given).
+// The queue is refilled in the background until the mount is cleaned up
+func (b *buddySecretBackend) refillPoolAsync(s logical.Storage, roleNames ...string) {
+	b.refillLock.Lock()
+	defer b.refillLock.Unlock()
+	if len(roleNames) == 0 {
+		b.refillAll = true
+	}
+	for _, name := range roleNames {
+		b.refillQueue[name] = true
+	}
+	// the running worker picks up the queued roles
+	if b.refillRunning || b.ctx.Err() != nil {
+		return
+	}
+	b.refillRunning = true
+	b.refillWg.Add(1)
+	go b.refillWorker(s)
+}
+
+// refillWorker refills the queued pools until the queue is empty
+func (b *buddySecretBackend) refillWorker(s logical.Storage) {
+	defer b.refillWg.Done()
+	for {
+		b.refillLock.Lock()
+		if b.ctx.Err() != nil || (!b.refillAll && len(b.refillQueue) == 0) {
+			b.refillRunning = false
+			b.refillLock.Unlock()
+			return
+		}
+		var roleNames []string
+		if !b.refillAll {
+			for name := range b.refillQueue {
+				roleNames = append(roleNames, name)
+			}
+		}
+		b.refillAll = false
+		b.refillQueue = map[string]bool{}
+		b.refillLock.Unlock()
+		if err := b.refillPools(b.ctx, s, roleNames...); err != nil {
+			b.Logger().Info("error while refilling token pool", "roles", roleNames, "error", err)
+		}
+	}
+}
+
+// cleanup stops the background refills and deletes the pooled tokens when the mount is unloaded
+func (b *buddySecretBackend) cleanup(ctx context.Context) {
+	b.cancel()
+	b.refillWg.Wait()
+	// performance standbys cannot write the local storage of the active node
+	if b.storage == nil || b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby) {
+		return
+	}
+	if err := b.drainPools(ctx, b.storage); err != nil {
+		b.Logger().Warn("error while deleting pooled tokens", "error", err)
+	}
+}
+
+// drainPools deletes every pooled token, the storage entry first so a token deleted in Buddy is never handed out
+func (b *buddySecretBackend) drainPools(ctx context.Context, s logical.Storage) error {
+	roleNames, err := s.List(ctx, poolStoragePath+"/")
+	if err != nil {
+		return err
+	}
+	for _, roleName := range roleNames {
+		roleName = strings.TrimSuffix(roleName, "/")
+		if err := b.drainPool(ctx, s, roleName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// drainPool deletes the pooled tokens of the role
+func (b *buddySecretBackend) drainPool(ctx context.Context, s logical.Storage, roleName string) error {
+	b.poolLock.Lock()
+	tokens, err := listPooledTokens(ctx, s, roleName)
+	if err == nil {
+		for _, token := range tokens {
+			if err = deletePooledToken(ctx, s, roleName, token.TokenId); err != nil {
+				break
+			}
+		}
+	}
+	b.poolLock.Unlock()
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		err := b.deleteWorkspaceToken(ctx, s, token.RootWorkspace, token.TokenId)
+		if err != nil && !isNotFound(err) {
+			b.Logger().Info("error while deleting pooled token", "role", roleName, "token_id", token.TokenId, "error", err)
+		}
+	}
+	return nil
+}
+
+// refillPools deletes the stale and surplus tokens from the pools of the given roles
+// (or every role if none is given) and creates the missing ones
+func (b *buddySecretBackend) refillPools(ctx context.Context, s logical.Storage, roleNames ...string) error {
+	// one refill at a time, the queued ones wait for the running one
+	b.poolRefillLock.Lock()
+	defer b.poolRefillLock.Unlock()
+	if len(roleNames) == 0 {
+		pools, err := s.List(ctx, poolStoragePath+"/")
+		if err != nil {
+			return err
+		}
+		roles, err := s.List(ctx, rolesStoragePath+"/")
+		if err != nil {
+			return err
+		}
+		seen := map[string]bool{}
+		for _, name := range append(pools, roles...) {
+			name = strings.TrimSuffix(name, "/")
+			if !seen[name] {
+				seen[name] = true
+				roleNames = append(roleNames, name)
+			}
+		}
+	}
+	config, err := b.getConfig(ctx, s)
+	if err != nil {
+		return err
+	}
+	if config == nil || !config.hasRootCredential() {
+		return nil
+	}
+	policy, err := getPolicy(ctx, s)
+	if err != nil {
+		return err
+	}
+	for _, roleName := range roleNames {
+		// the mount is unloaded
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		role, err := getEffectiveRole(ctx, roleName, s)
+		if err != nil {
+			return err
+		}
+		poolSize := 0
+		fingerprint := ""
+		if role != nil {
+			poolSize = role.PoolSize
+			fingerprint = roleFingerprint(role)
+		}
+		// the credentials of the role are refused, so its pool is drained
+		var violation error
+		if poolSize > 0 {
+			if violation = policy.check(role, b.System()); violation != nil {
+				poolSize = 0
+			}
+		}
+		b.poolLock.Lock()
+		tokens, err := listPooledTokens(ctx, s, roleName)
+		if err != nil {
+			b.poolLock.Unlock()
+			return err
+		}
+		if violation != nil && len(tokens) > 0 {
+			b.Logger().Warn("role violates the mount policy - draining its pool", "role", roleName, "error", violation)
+		}
+		var surplus []*pooledToken
+		valid := 0
+		for _, token := range tokens {
+			if token.Fingerprint == fingerprint && valid < poolSize {
+				valid++
+				continue
+			}
+			if err := deletePooledToken(ctx, s, roleName, token.TokenId); err != nil {
+				b.poolLock.Unlock()
+				return err
+			}
+			surplus = append(surplus, token)
+		}
+		b.poolLock.Unlock()
+		for _, token := range surplus {
+			if err := b.deleteWorkspaceToken(ctx, s, token.RootWorkspace, token.TokenId); err != nil {
+				b.Logger().Info("error while deleting pooled token", "role", roleName, "error", err)
+			}
+		}
+		if valid >= poolSize {
+			continue
+		}
+		rootWorkspace, err := config.roleWorkspace(role)
+		if err != nil {
+			b.Logger().Warn("unable to refill token pool", "role", roleName, "error", err)
+			continue
+		}
+		rootTokenId := config.TokenId
+		if rootWorkspace != "" {
+			rootTokenId = config.WorkspaceTokens[rootWorkspace].TokenId
+		}
+		client, err := b.getWorkspaceClient(ctx, s, rootWorkspace)
+		if err != nil {
+			return err
+		}
+		for i := valid; i < poolSize; i++ {
+			token, err := client.CreateToken(fmt.Sprintf("vault token for '%s' role", roleName), TokenDefaultExpiration, role.IpRestrictions, role.WorkspaceRestrictions, role.Scopes)
+			if err != nil {
+				return err
+			}
+			err = putPooledToken(ctx, s, roleName, &pooledToken{
+				TokenId:       token.Id,
+				Token:         token.Token,
+				Fingerprint:   fingerprint,
+				RootTokenId:   rootTokenId,
+				RootWorkspace: rootWorkspace,
+				CreatedAt:     time.Now(),
+			})
+			if err != nil {
+				incrCounter([]string{"pool", "rollback"}, metrics.Label{Name: "role", Value: roleName})
+				_ = client.DeleteToken(token.Id)
+				return err
+			}
+		}
+	}
+	return nil
+}
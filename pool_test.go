@@ -0,0 +1,47 @@
+package buddysecrets
+
+import (
+	"context"
+	"github.com/hashicorp/vault/sdk/logical"
+	"testing"
+)
+
+func TestRefillPoolsDrainsPolicyViolations(t *testing.T) {
+	f := newFakeBuddy(t)
+	b, s := getTestBackend(t, 0)
+	testConfigure(t, b, s, f)
+	ctx := context.Background()
+	testOk(t, b, s, logical.CreateOperation, "roles/r1", map[string]interface{}{
+		"scopes":    "WORKSPACE",
+		"pool_size": 2,
+	})
+	if err := b.refillPools(ctx, s, "r1"); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := listPooledTokens(ctx, s, "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 {
+		t.Fatalf("expected 2 pooled tokens, got %d", len(tokens))
+	}
+
+	testOk(t, b, s, logical.UpdateOperation, "config/policy", map[string]interface{}{
+		"denied_scopes": "WORKSPACE",
+	})
+	if err := b.refillPools(ctx, s, "r1"); err != nil {
+		t.Fatal(err)
+	}
+	drained, err := listPooledTokens(ctx, s, "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drained) != 0 {
+		t.Fatalf("pool of the role violating the policy must be drained, got %d tokens", len(drained))
+	}
+	for _, token := range tokens {
+		if f.exists(token.TokenId) {
+			t.Fatalf("pooled token %s must be deleted in buddy", token.TokenId)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package buddysecrets
+
+import (
+	"context"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/logical"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var performanceReplicaStates = map[string]consts.ReplicationState{
+	"secondary": consts.ReplicationPerformanceSecondary,
+	"standby":   consts.ReplicationPerformanceStandby,
+}
+
+// recordingStorage records the keys written and deleted by the node
+type recordingStorage struct {
+	logical.Storage
+	lock   sync.Mutex
+	writes []string
+}
+
+func (s *recordingStorage) Put(ctx context.Context, entry *logical.StorageEntry) error {
+	s.record(entry.Key)
+	return s.Storage.Put(ctx, entry)
+}
+
+func (s *recordingStorage) Delete(ctx context.Context, key string) error {
+	s.record(key)
+	return s.Storage.Delete(ctx, key)
+}
+
+func (s *recordingStorage) record(key string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.writes = append(s.writes, key)
+}
+
+func (s *recordingStorage) written() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string{}, s.writes...)
+}
+
+func TestSecondaryCredsWriteLocalStorageOnly(t *testing.T) {
+	// performance standbys forward the credentials to the active node, see TestStandbyForwardsCreds
+	f := newFakeBuddy(t)
+	primary, shared := getTestBackend(t, 0)
+	testConfigure(t, primary, shared, f)
+	testOk(t, primary, shared, logical.CreateOperation, "roles/r1", map[string]interface{}{
+		"scopes":            "WORKSPACE",
+		"max_active_leases": 5,
+	})
+	testOk(t, primary, shared, logical.CreateOperation, "roles/r2", map[string]interface{}{
+		"scopes":    "WORKSPACE",
+		"pool_size": 1,
+	})
+
+	s := &recordingStorage{Storage: shared}
+	b := getTestBackendWithStorage(t, consts.ReplicationPerformanceSecondary, s)
+	if err := b.refillPools(context.Background(), s, "r2"); err != nil {
+		t.Fatal(err)
+	}
+	for _, role := range []string{"r1", "r2"} {
+		resp := testOk(t, b, s, logical.ReadOperation, "creds/"+role, nil)
+		if err := testRevoke(t, b, s, resp.Secret); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testOk(t, b, s, logical.UpdateOperation, "roles/r1/revoke-all", nil)
+	testOk(t, b, s, logical.UpdateOperation, "revoke-orphans", nil)
+
+	local := []string{tokensStoragePath + "/", poolStoragePath + "/", usageStoragePath + "/"}
+	writes := s.written()
+	if len(writes) == 0 {
+		t.Fatal("expected the issued tokens to be written")
+	}
+	for _, key := range writes {
+		isLocal := false
+		for _, prefix := range local {
+			if strings.HasPrefix(key, prefix) {
+				isLocal = true
+			}
+		}
+		if !isLocal {
+			t.Errorf("%s is replicated and must not be written", key)
+		}
+	}
+}
+
+// testForwarded checks that the node leaves the request to the active node of the primary cluster
+func testForwarded(t *testing.T, b *buddySecretBackend, s logical.Storage, op logical.Operation, path string, data map[string]interface{}) {
+	t.Helper()
+	_, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: op,
+		Path:      path,
+		Storage:   s,
+		Data:      data,
+	})
+	if err != logical.ErrReadOnly {
+		t.Errorf("%s %s must be forwarded, got %v", op, path, err)
+	}
+}
+
+func TestStandbyForwardsCreds(t *testing.T) {
+	f := newFakeBuddy(t)
+	primary, s := getTestBackend(t, 0)
+	testConfigure(t, primary, s, f)
+	testOk(t, primary, s, logical.CreateOperation, "roles/r1", map[string]interface{}{
+		"scopes": "WORKSPACE",
+	})
+	b := getTestBackendWithStorage(t, consts.ReplicationPerformanceStandby, s)
+	testForwarded(t, b, s, logical.ReadOperation, "creds/r1", nil)
+	testForwarded(t, b, s, logical.UpdateOperation, "roles/r1/revoke-all", nil)
+	testForwarded(t, b, s, logical.UpdateOperation, "revoke-orphans", nil)
+}
+
+func TestReplicaForwardsSharedWrites(t *testing.T) {
+	for name, state := range performanceReplicaStates {
+		t.Run(name, func(t *testing.T) {
+			b, s := getTestBackend(t, state)
+			testForwarded(t, b, s, logical.CreateOperation, "config", map[string]interface{}{
+				"token": "root",
+			})
+			testForwarded(t, b, s, logical.CreateOperation, "roles/r1", map[string]interface{}{
+				"scopes": "WORKSPACE",
+			})
+			testForwarded(t, b, s, logical.DeleteOperation, "roles/r1", nil)
+		})
+	}
+}
+
+func TestSharedWritesAreForwarded(t *testing.T) {
+	b, _ := getTestBackend(t, 0)
+	paths := []string{
+		"config",
+		"config/policy",
+		"rotate-root",
+		"roles/r1",
+		"roles/r1/rollback",
+		"role-templates/t1",
+		"scope-sets/s1",
+		"integration-roles/i1",
+		"elevation-roles/e1",
+		"import",
+	}
+	for _, path := range paths {
+		p := b.Route(path)
+		if p == nil {
+			t.Fatalf("no path for %s", path)
+		}
+		writes := 0
+		for _, op := range []logical.Operation{logical.CreateOperation, logical.UpdateOperation, logical.DeleteOperation} {
+			handler, ok := p.Operations[op]
+			if !ok {
+				continue
+			}
+			writes++
+			props := handler.Properties()
+			if !props.ForwardPerformanceSecondary || !props.ForwardPerformanceStandby {
+				t.Errorf("%s %s must be forwarded to the primary cluster", op, path)
+			}
+		}
+		if writes == 0 {
+			t.Errorf("%s has no write operations", path)
+		}
+	}
+}
+
+func TestReplicaPeriodicSkipsRotation(t *testing.T) {
+	t.Setenv("BUDDY_FORCE_RORATE", "true")
+	for name, state := range performanceReplicaStates {
+		t.Run(name, func(t *testing.T) {
+			f := newFakeBuddy(t)
+			primary, shared := getTestBackend(t, 0)
+			root := testConfigure(t, primary, shared, f)
+			testOk(t, primary, shared, logical.UpdateOperation, "config", map[string]interface{}{
+				"token_auto_rotate": true,
+			})
+
+			s := &recordingStorage{Storage: shared}
+			b := getTestBackendWithStorage(t, state, s)
+			if err := b.periodic(context.Background(), &logical.Request{Storage: s}); err != nil {
+				t.Fatal(err)
+			}
+			if writes := s.written(); len(writes) != 0 {
+				t.Fatalf("periodic must not write %v", writes)
+			}
+			if !f.exists(root.Id) {
+				t.Fatal("root token must not be rotated")
+			}
+
+			// the primary cluster rotates the same config
+			if err := primary.periodic(context.Background(), &logical.Request{Storage: shared}); err != nil {
+				t.Fatal(err)
+			}
+			config, err := primary.getConfig(context.Background(), shared)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.TokenId == root.Id || f.exists(root.Id) {
+				t.Fatal("primary must rotate the root token")
+			}
+			if !config.TokenExpiresAt.After(time.Now()) {
+				t.Fatalf("unexpected expiration of the rotated token %s", config.TokenExpiresAt)
+			}
+		})
+	}
+}
@@ -23,6 +23,10 @@ BUILD_TAGS="${BUILD_TAGS}:-${TOOL}"
 GIT_COMMIT="$(git rev-parse HEAD)"
 GIT_DIRTY="$(test -n "`git status --porcelain`" && echo "+CHANGES" || true)"
 
+# Get the version from the last tag, Vault requires semantic versions prefixed with v
+VERSION="${VERSION:-$(git describe --tags --match 'v*' 2>/dev/null || echo v0.0.0-dev)}"
+BUILD_DATE="$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+
 GOPATH=${GOPATH:-$(go env GOPATH)}
 case $(uname) in
     CYGWIN*)
@@ -40,7 +44,7 @@ mkdir -p bin/
 echo "Building..."
 ${GO_CMD} build \
     -gcflags "${GCFLAGS}" \
-    -ldflags "-X github.com/buddy/${TOOL}/version.GitCommit='${GIT_COMMIT}${GIT_DIRTY}'" \
+    -ldflags "-X github.com/buddy/${TOOL}/version.GitCommit='${GIT_COMMIT}${GIT_DIRTY}' -X github.com/buddy/${TOOL}/version.Version='${VERSION}' -X github.com/buddy/${TOOL}/version.BuildDate='${BUILD_DATE}'" \
     -o "bin/${TOOL}" \
     -tags "${BUILD_TAGS}" \
     "${DIR}/cmd/${TOOL}"
@@ -0,0 +1,38 @@
+package buddysecrets
+
+import (
+	"fmt"
+	"github.com/hashicorp/vault/sdk/logical"
+	"strings"
+)
+
+// sealWrapStorage are the storage paths holding secret material: the root credentials, the
+// integration credentials and the pooled token values. Entries outside of them must not hold secrets
+var sealWrapStorage = []string{
+	configStoragePath,
+	integrationRolesStoragePath + "/",
+	poolStoragePath + "/",
+}
+
+func isSealWrapped(key string) bool {
+	for _, path := range sealWrapStorage {
+		if key == path || (strings.HasSuffix(path, "/") && strings.HasPrefix(key, path)) {
+			return true
+		}
+	}
+	return false
+}
+
+// secretEntryJSON creates the storage entry holding secret material. It refuses the keys which
+// are not seal-wrapped, so secrets are never written to plain entries by mistake
+func secretEntryJSON(key string, v interface{}) (*logical.StorageEntry, error) {
+	if !isSealWrapped(key) {
+		return nil, fmt.Errorf("storage entry '%s' holds secrets and must be seal-wrapped", key)
+	}
+	entry, err := logical.StorageEntryJSON(key, v)
+	if err != nil {
+		return nil, err
+	}
+	entry.SealWrap = true
+	return entry, nil
+}
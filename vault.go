@@ -0,0 +1,42 @@
+package buddysecrets
+
+import (
+	"fmt"
+	"github.com/hashicorp/vault/api"
+	"slices"
+	"strings"
+)
+
+// checkCredentialsPath returns an error if the credentials cannot be read from the path, the path
+// must start with one of the credentials_path_prefixes of config
+func checkCredentialsPath(config *buddyConfig, path string) error {
+	path = strings.TrimPrefix(path, "/")
+	if slices.Contains(strings.Split(path, "/"), "..") {
+		return fmt.Errorf("credentials_path '%s' cannot contain '..'", path)
+	}
+	if config != nil {
+		for _, prefix := range config.CredentialsPathPrefixes {
+			if strings.HasPrefix(path, strings.TrimPrefix(prefix, "/")) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("credentials_path '%s' is not allowed by credentials_path_prefixes of the config", path)
+}
+
+// newVaultClient creates the client of the Vault server using the address and the token saved in config
+func newVaultClient(config *buddyConfig) (*api.Client, error) {
+	if config.VaultToken == "" {
+		return nil, fmt.Errorf("vault token not provided through config")
+	}
+	vaultConfig := api.DefaultConfig()
+	if config.VaultAddr != "" {
+		vaultConfig.Address = config.VaultAddr
+	}
+	vaultClient, err := api.NewClient(vaultConfig)
+	if err != nil {
+		return nil, err
+	}
+	vaultClient.SetToken(config.VaultToken)
+	return vaultClient, nil
+}
@@ -0,0 +1,70 @@
+package buddysecrets
+
+import (
+	"context"
+	"github.com/hashicorp/vault/sdk/logical"
+	"strings"
+	"testing"
+)
+
+func TestCheckCredentialsPath(t *testing.T) {
+	config := &buddyConfig{
+		CredentialsPathPrefixes: []string{"secret/data/buddy/", "/kv/aws"},
+	}
+	tests := []struct {
+		name    string
+		config  *buddyConfig
+		path    string
+		allowed bool
+	}{
+		{name: "prefix", config: config, path: "secret/data/buddy/aws", allowed: true},
+		{name: "leading slash", config: config, path: "/secret/data/buddy/aws", allowed: true},
+		{name: "prefix with leading slash", config: config, path: "kv/aws", allowed: true},
+		{name: "outside of prefixes", config: config, path: "secret/data/other", allowed: false},
+		{name: "parent segment", config: config, path: "secret/data/buddy/../other", allowed: false},
+		{name: "no prefixes", config: &buddyConfig{}, path: "secret/data/buddy/aws", allowed: false},
+		{name: "no config", config: nil, path: "secret/data/buddy/aws", allowed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkCredentialsPath(tt.config, tt.path)
+			if tt.allowed && err != nil {
+				t.Fatalf("path must be allowed, got %s", err)
+			}
+			if !tt.allowed && err == nil {
+				t.Fatal("path must be refused")
+			}
+		})
+	}
+}
+
+func TestCredentialsPathPrefixes(t *testing.T) {
+	f := newFakeBuddy(t)
+	b, s := getTestBackend(t, 0)
+	testCredentialsPathPrefixes(t, b, s, f, "secret/data/buddy/")
+	role := map[string]interface{}{
+		"workspace":        "acme",
+		"type":             "AMAZON",
+		"credentials_path": "secret/data/other",
+	}
+	resp := testRequest(t, b, s, logical.CreateOperation, "integration-roles/i1", role)
+	if !resp.IsError() {
+		t.Fatal("credentials_path outside of credentials_path_prefixes must be refused")
+	}
+	role["credentials_path"] = "secret/data/buddy/aws"
+	testOk(t, b, s, logical.CreateOperation, "integration-roles/i1", role)
+
+	// the prefix removed after the role was written is checked again when the credentials are read
+	testOk(t, b, s, logical.UpdateOperation, "config", map[string]interface{}{
+		"credentials_path_prefixes": "secret/data/other/",
+		"vault_token":               "vault-token",
+	})
+	config, err := b.getConfig(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = readVaultCredentials(context.Background(), config, "secret/data/buddy/aws")
+	if err == nil || !strings.Contains(err.Error(), "credentials_path_prefixes") {
+		t.Fatalf("credentials must not be read from the path, got %v", err)
+	}
+}
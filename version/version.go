@@ -0,0 +1,50 @@
+// Package version holds the build metadata of the plugin, set with the linker flags
+// by scripts/build.sh and goreleaser
+package version
+
+import (
+	"runtime/debug"
+)
+
+var (
+	// Version is the semantic version of the plugin reported to Vault, e.g. v1.2.0
+	Version = "v0.0.0-dev"
+	// GitCommit is the commit the plugin was built from
+	GitCommit = ""
+	// BuildDate is the RFC3339 date of the build
+	BuildDate = ""
+)
+
+// Dependency returns the version of the module the plugin was built with, empty if unknown
+func Dependency(path string) string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == path {
+			if dep.Replace != nil {
+				return dep.Replace.Version
+			}
+			return dep.Version
+		}
+	}
+	return ""
+}
+
+// Commit returns GitCommit, falling back to the VCS revision recorded by the go toolchain
+func Commit() string {
+	if GitCommit != "" {
+		return GitCommit
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+	return ""
+}
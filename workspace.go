@@ -0,0 +1,232 @@
+package buddysecrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/vault/sdk/logical"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// errWorkspaceNotConfigured is returned for the workspace whose root token is not in config
+var errWorkspaceNotConfigured = errors.New("root token of workspace not provided through config")
+
+// workspaceRootToken is the root token of the Buddy workspace with its own rotation state
+type workspaceRootToken struct {
+	Token                      string    `json:"token"`
+	TokenAutoRotate            bool      `json:"token_auto_rotate"`
+	TokenAutoRotateAt          time.Time `json:"token_auto_rotate_at"`
+	TokenId                    string    `json:"token_id"`
+	TokenExpiresAt             time.Time `json:"token_expires_at"`
+	TokenNoExpiration          bool      `json:"token_no_expiration"`
+	TokenScopes                []string  `json:"token_scopes"`
+	TokenIpRestrictions        []string  `json:"token_ip_restrictions"`
+	TokenWorkspaceRestrictions []string  `json:"token_workspace_restrictions"`
+}
+
+// workspaceDomains returns the domains of the workspace root tokens in a stable order
+func (c *buddyConfig) workspaceDomains() []string {
+	domains := make([]string, 0, len(c.WorkspaceTokens))
+	for domain := range c.WorkspaceTokens {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+// forWorkspace returns the config of the workspace root token, so it is validated, used and rotated
+// the same way as the default root token
+func (c *buddyConfig) forWorkspace(domain string) *buddyConfig {
+	ws := c.WorkspaceTokens[domain]
+	return &buddyConfig{
+		Token:                      ws.Token,
+		BaseUrl:                    c.BaseUrl,
+		Insecure:                   c.Insecure,
+		TokenAutoRotate:            ws.TokenAutoRotate,
+		TokenAutoRotateAt:          ws.TokenAutoRotateAt,
+		TokenTtlInDays:             c.TokenTtlInDays,
+		TokenId:                    ws.TokenId,
+		TokenExpiresAt:             ws.TokenExpiresAt,
+		TokenNoExpiration:          ws.TokenNoExpiration,
+		TokenScopes:                ws.TokenScopes,
+		TokenIpRestrictions:        ws.TokenIpRestrictions,
+		TokenWorkspaceRestrictions: ws.TokenWorkspaceRestrictions,
+	}
+}
+
+// putWorkspace stores the root token of the workspace config returned by forWorkspace
+func (c *buddyConfig) putWorkspace(domain string, wc *buddyConfig) {
+	if c.WorkspaceTokens == nil {
+		c.WorkspaceTokens = map[string]*workspaceRootToken{}
+	}
+	c.WorkspaceTokens[domain] = &workspaceRootToken{
+		Token:                      wc.Token,
+		TokenAutoRotate:            wc.TokenAutoRotate,
+		TokenAutoRotateAt:          wc.TokenAutoRotateAt,
+		TokenId:                    wc.TokenId,
+		TokenExpiresAt:             wc.TokenExpiresAt,
+		TokenNoExpiration:          wc.TokenNoExpiration,
+		TokenScopes:                wc.TokenScopes,
+		TokenIpRestrictions:        wc.TokenIpRestrictions,
+		TokenWorkspaceRestrictions: wc.TokenWorkspaceRestrictions,
+	}
+}
+
+// roleWorkspace returns the workspace whose root token issues the tokens of the role, empty for the
+// default root token. The root token must be restricted to every workspace of the role. Once workspace
+// root tokens are configured, the role with workspace_restrictions must match one of them
+func (c *buddyConfig) roleWorkspace(role *roleEntry) (string, error) {
+	if len(role.WorkspaceRestrictions) == 0 || len(c.WorkspaceTokens) == 0 {
+		return "", nil
+	}
+	for _, domain := range c.workspaceDomains() {
+		restrictions := c.WorkspaceTokens[domain].TokenWorkspaceRestrictions
+		matches := true
+		for _, workspace := range role.WorkspaceRestrictions {
+			if !slices.Contains(restrictions, workspace) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return domain, nil
+		}
+	}
+	return "", fmt.Errorf("no workspace root token is restricted to every workspace of the role: %s", strings.Join(role.WorkspaceRestrictions, ", "))
+}
+
+// isRootTokenId reports whether the id is of the default or any workspace root token
+func (c *buddyConfig) isRootTokenId(id string) bool {
+	if id == c.TokenId {
+		return true
+	}
+	for _, ws := range c.WorkspaceTokens {
+		if id == ws.TokenId {
+			return true
+		}
+	}
+	return false
+}
+
+// getWorkspaceClient returns the client of the workspace root token, the default client for the empty workspace
+func (b *buddySecretBackend) getWorkspaceClient(ctx context.Context, s logical.Storage, domain string) (*client, error) {
+	if domain == "" {
+		return b.getClient(ctx, s)
+	}
+	b.lock.RLock()
+	if c := b.workspaceClients[domain]; c.Valid() {
+		b.lock.RUnlock()
+		return c, nil
+	}
+	b.lock.RUnlock()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if c := b.workspaceClients[domain]; c.Valid() {
+		return c, nil
+	}
+	config, err := b.getConfig(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil || config.WorkspaceTokens[domain] == nil {
+		return nil, fmt.Errorf("%w: %s", errWorkspaceNotConfigured, domain)
+	}
+	wc := config.forWorkspace(domain)
+	apiClient, err := NewApiClient(wc)
+	if err != nil {
+		return nil, err
+	}
+	c := &client{
+		expiration: clientExpiration(wc),
+		apiClient:  apiClient,
+		logger:     b.Logger().With("workspace", domain),
+	}
+	b.workspaceClients[domain] = c
+	return c, nil
+}
+
+// deleteWorkspaceToken deletes the token created by the root token of the workspace. The tokens of
+// a workspace removed from config are deleted by the default root token. The error is returned
+// either way, so the revocation is retried instead of leaving the long-lived token in Buddy
+func (b *buddySecretBackend) deleteWorkspaceToken(ctx context.Context, s logical.Storage, domain string, tokenId string) error {
+	client, err := b.getWorkspaceClient(ctx, s, domain)
+	if errors.Is(err, errWorkspaceNotConfigured) {
+		client, err = b.getClient(ctx, s)
+		if err != nil {
+			return err
+		}
+		return client.DeleteToken(tokenId)
+	}
+	if err != nil {
+		return err
+	}
+	return client.DeleteToken(tokenId)
+}
+
+// workspaceInUse reports whether the issued or pooled tokens of this cluster were created by the
+// root token of the workspace. The tokens are kept in the local storage, so the tokens issued by
+// the performance secondaries are not seen and are deleted by the root credentials instead
+func workspaceInUse(ctx context.Context, s logical.Storage, domain string) (bool, error) {
+	issued, err := listIssuedTokens(ctx, s, "")
+	if err != nil {
+		return false, err
+	}
+	for _, token := range issued {
+		if token.RootWorkspace == domain {
+			return true, nil
+		}
+	}
+	pools, err := s.List(ctx, poolStoragePath+"/")
+	if err != nil {
+		return false, err
+	}
+	for _, roleName := range pools {
+		pooled, err := listPooledTokens(ctx, s, strings.TrimSuffix(roleName, "/"))
+		if err != nil {
+			return false, err
+		}
+		for _, token := range pooled {
+			if token.RootWorkspace == domain {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// periodicWorkspace rotates the root token of the workspace, the same way as the default root token
+func (b *buddySecretBackend) periodicWorkspace(ctx context.Context, sys *logical.Request, domain string) error {
+	config, err := b.getConfig(ctx, sys.Storage)
+	if err != nil {
+		return err
+	}
+	if config == nil || config.WorkspaceTokens[domain] == nil {
+		return nil
+	}
+	ws := config.WorkspaceTokens[domain]
+	if !ws.TokenAutoRotate {
+		return nil
+	}
+	logger := b.Logger().With("workspace", domain, "token_id", ws.TokenId)
+	now := time.Now()
+	if !ws.TokenNoExpiration && ws.TokenExpiresAt.Unix() < now.Unix() {
+		logger.Warn("workspace root token expired - disabling auto rotate", "expires_at", ws.TokenExpiresAt)
+		ws.TokenAutoRotate = false
+		return b.saveConfig(ctx, config, sys.Storage)
+	}
+	forceRotate := os.Getenv("BUDDY_FORCE_RORATE") == "true"
+	if forceRotate || ws.TokenAutoRotateAt.Unix() < now.Unix() {
+		logger.Info("rotating workspace root token", "rotate_at", ws.TokenAutoRotateAt, "forced", forceRotate)
+		err := b.rotateRootToken(ctx, sys, domain)
+		if err != nil {
+			ws.TokenAutoRotateAt = ws.TokenAutoRotateAt.Add(time.Hour)
+			logger.Error("error while rotating workspace root token - will try in an hour", "retry_at", ws.TokenAutoRotateAt, "error", config.redact(err.Error()))
+			return b.saveConfig(ctx, config, sys.Storage)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package buddysecrets
+
+import (
+	"context"
+	"github.com/hashicorp/vault/sdk/logical"
+	"testing"
+	"time"
+)
+
+func testConfigureWorkspace(t *testing.T, b *buddySecretBackend, s logical.Storage, f *fakeBuddy, domain string) {
+	t.Helper()
+	ws := f.addRootToken(domain)
+	testOk(t, b, s, logical.UpdateOperation, "config", map[string]interface{}{
+		"workspace_tokens": map[string]interface{}{
+			domain: ws.Token,
+		},
+	})
+}
+
+func TestWorkspaceRemovalWithIssuedTokens(t *testing.T) {
+	f := newFakeBuddy(t)
+	b, s := getTestBackend(t, 0)
+	testConfigure(t, b, s, f)
+	testConfigureWorkspace(t, b, s, f, "acme")
+	testOk(t, b, s, logical.CreateOperation, "roles/r1", map[string]interface{}{
+		"scopes":                 "WORKSPACE",
+		"workspace_restrictions": "acme",
+	})
+	resp := testOk(t, b, s, logical.ReadOperation, "creds/r1", nil)
+	if resp.Secret.InternalData["root_workspace"] != "acme" {
+		t.Fatalf("expected the token issued by the acme root token, got %v", resp.Secret.InternalData["root_workspace"])
+	}
+
+	resp = testRequest(t, b, s, logical.UpdateOperation, "config", map[string]interface{}{
+		"workspace_tokens": map[string]interface{}{},
+	})
+	if !resp.IsError() {
+		t.Fatal("workspace root token with issued tokens must not be removed")
+	}
+
+	if err := testRevoke(t, b, s, testOk(t, b, s, logical.ReadOperation, "creds/r1", nil).Secret); err != nil {
+		t.Fatal(err)
+	}
+	testOk(t, b, s, logical.UpdateOperation, "roles/r1/revoke-all", nil)
+	testOk(t, b, s, logical.UpdateOperation, "config", map[string]interface{}{
+		"workspace_tokens": map[string]interface{}{},
+	})
+}
+
+func TestRevokeTokenOfRemovedWorkspace(t *testing.T) {
+	f := newFakeBuddy(t)
+	b, s := getTestBackend(t, 0)
+	root := testConfigure(t, b, s, f)
+	// issued by another cluster with the workspace root token which is no longer configured
+	token := f.addRootToken()
+	err := saveIssuedToken(context.Background(), s, &issuedToken{
+		TokenId:       token.Id,
+		Role:          "r1",
+		CreatedAt:     time.Now(),
+		RootTokenId:   root.Id,
+		RootWorkspace: "removed",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := testOk(t, b, s, logical.UpdateOperation, "roles/r1/revoke-all", nil)
+	if revoked := resp.Data["revoked_token_ids"].([]string); len(revoked) != 1 {
+		t.Fatalf("expected the token to be revoked, got %v", revoked)
+	}
+	if f.exists(token.Id) {
+		t.Fatal("token must be deleted by the root credentials")
+	}
+
+	err = testRevoke(t, b, s, &logical.Secret{
+		InternalData: map[string]interface{}{
+			"secret_type":    SecretTypeToken,
+			"token_id":       "unknown",
+			"role":           "r1",
+			"root_workspace": "removed",
+		},
+	})
+	if err != nil {
+		t.Fatalf("lease of the removed workspace must be revoked, got %s", err)
+	}
+}
+
+func TestRoleWithoutWorkspaceRootToken(t *testing.T) {
+	f := newFakeBuddy(t)
+	b, s := getTestBackend(t, 0)
+	testConfigure(t, b, s, f)
+	testOk(t, b, s, logical.CreateOperation, "roles/r1", map[string]interface{}{
+		"scopes":                 "WORKSPACE",
+		"workspace_restrictions": "other",
+	})
+	// the root credentials issue the tokens while no workspace root token is configured
+	testOk(t, b, s, logical.ReadOperation, "creds/r1", nil)
+
+	testConfigureWorkspace(t, b, s, f, "acme")
+	resp := testRequest(t, b, s, logical.ReadOperation, "creds/r1", nil)
+	if !resp.IsError() {
+		t.Fatal("role not matching any workspace root token must not fall back to the root credentials")
+	}
+}
+
+func TestRevokeTokenOfRemovedWorkspaceRetried(t *testing.T) {
+	f := newFakeBuddy(t)
+	b, s := getTestBackend(t, 0)
+	root := testConfigure(t, b, s, f)
+	token := f.addRootToken()
+	secret := &logical.Secret{
+		InternalData: map[string]interface{}{
+			"secret_type":    SecretTypeToken,
+			"token_id":       token.Id,
+			"role":           "r1",
+			"root_workspace": "removed",
+		},
+	}
+	// the root credentials can no longer delete the token
+	f.lock.Lock()
+	delete(f.tokens, root.Token)
+	f.lock.Unlock()
+	if err := testRevoke(t, b, s, secret); err == nil {
+		t.Fatal("revocation must fail, so Vault retries it")
+	}
+	if !f.exists(token.Id) {
+		t.Fatal("token must not be deleted")
+	}
+}
+
+func TestWorkspaceTokenAutoRotate(t *testing.T) {
+	f := newFakeBuddy(t)
+	b, s := getTestBackend(t, 0)
+	testConfigure(t, b, s, f)
+	acme := f.addRootToken("acme")
+	labs := f.addRootToken("acme-labs")
+	testOk(t, b, s, logical.UpdateOperation, "config", map[string]interface{}{
+		"token_auto_rotate": true,
+		"workspace_tokens": map[string]interface{}{
+			"acme":      acme.Token,
+			"acme-labs": labs.Token,
+		},
+		"workspace_token_auto_rotate": map[string]interface{}{
+			"acme": "true",
+		},
+	})
+	autoRotate := func() map[string]bool {
+		t.Helper()
+		config, err := b.getConfig(context.Background(), s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := map[string]bool{}
+		for domain, ws := range config.WorkspaceTokens {
+			got[domain] = ws.TokenAutoRotate
+		}
+		return got
+	}
+	// the root credentials setting is not copied to the workspaces
+	if got := autoRotate(); !got["acme"] || got["acme-labs"] {
+		t.Fatalf("unexpected auto-rotation of the workspaces %v", got)
+	}
+
+	// replacing the tokens keeps the setting of the workspaces
+	testOk(t, b, s, logical.UpdateOperation, "config", map[string]interface{}{
+		"workspace_tokens": map[string]interface{}{
+			"acme":      acme.Token,
+			"acme-labs": labs.Token,
+		},
+	})
+	if got := autoRotate(); !got["acme"] || got["acme-labs"] {
+		t.Fatalf("unexpected auto-rotation of the workspaces %v", got)
+	}
+
+	tests := map[string]map[string]interface{}{
+		"unknown workspace": {"other": "true"},
+		"invalid value":     {"acme": "yes please"},
+	}
+	for name, value := range tests {
+		resp := testRequest(t, b, s, logical.UpdateOperation, "config", map[string]interface{}{
+			"workspace_token_auto_rotate": value,
+		})
+		if !resp.IsError() {
+			t.Errorf("%s must be rejected", name)
+		}
+	}
+}